@@ -16,10 +16,17 @@ func main() {
 
 	converter.Add(frontend.AllowDTO{})
 	converter.Add(frontend.ResponseDTO{})
+	converter.Add(frontend.ErrorsDTO{})
+	converter.Add(frontend.RuleDTO{})
+	converter.Add(frontend.LocalRecordDTO{})
+	converter.Add(frontend.RuleKeyDTO{})
+	converter.Add(frontend.RevokeDTO{})
+	converter.Add(frontend.StatsDTO{})
+	converter.Add(frontend.EventDTO{})
 
 	err := converter.ConvertToFile(path.Join(`frontend`, `src`, `dto.ts`))
 	if err != nil {
-		_, _ = fmt.Fprint(os.Stderr, `error: %v`, err)
+		_, _ = fmt.Fprintf(os.Stderr, `error: %v`, err)
 		os.Exit(1)
 	}
 }
@@ -3,11 +3,15 @@ package main
 import (
 	"flag"
 	"fmt"
-	"github.com/raspi/torjuja/pkg/db/fsdb"
+	"github.com/raspi/torjuja/pkg/db"
 	"github.com/raspi/torjuja/pkg/db/iface"
 	"github.com/raspi/torjuja/pkg/service"
 	"os"
 	"strings"
+
+	// Storage backends selectable in configuration
+	_ "github.com/raspi/torjuja/pkg/db/fsdb"
+	_ "github.com/raspi/torjuja/pkg/db/memdb"
 )
 
 var (
@@ -66,9 +70,9 @@ func main() {
 		os.Exit(1)
 	}
 
-	var db iface.Database
+	var database iface.Database
 
-	db, err = fsdb.New(cfg.Database.FileSystem.Path)
+	database, err = db.Open(cfg.Database.Backend, cfg.Database.Block(cfg.Database.Backend))
 	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, `error: %v`, err)
 		os.Exit(1)
@@ -77,7 +81,7 @@ func main() {
 	errs := make(chan error)
 	defer close(errs)
 
-	s, err := service.New(cfg.ListenAddresses, cfg.Blocked, cfg.TTL, cfg.Forwarders, db, cfg.ApiListen, errs)
+	s, err := service.New(cfg, database, errs)
 	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, `error: %v`, err)
 		os.Exit(1)
@@ -88,7 +92,11 @@ func main() {
 		os.Exit(1)
 	}
 
-	fmt.Printf(`HTTP server http://%s`+"\n", cfg.ApiListen)
+	if cfg.TLS != nil {
+		fmt.Printf(`HTTP server https://%s`+"\n", cfg.ApiListen)
+	} else {
+		fmt.Printf(`HTTP server http://%s`+"\n", cfg.ApiListen)
+	}
 	for _, s := range cfg.ListenAddresses {
 		fmt.Printf(`DNS server %s`+"\n", s)
 	}
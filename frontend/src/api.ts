@@ -0,0 +1,92 @@
+import {AllowDTO, ErrorsDTO, EventDTO, RevokeDTO, RuleDTO, RuleKeyDTO} from './dto'
+
+// allow sends allow request to the API and returns error message or null on success
+export async function allow(dto: AllowDTO): Promise<string> {
+    const response: Response = await fetch('/api/v1/allow', {
+        method: 'POST',
+        headers: {
+            'Accept': 'application/json',
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(dto)
+    })
+
+    if (response.ok) {
+        return null
+    }
+
+    if (response.status === 400) {
+        const data = new ErrorsDTO(await response.json())
+        return data.errors.map(e => e.field + ': ' + e.msg).join(', ')
+    }
+
+    return response.status + ' ' + response.statusText
+}
+
+// eventBacklog gets events that happened before the page was opened, oldest first
+export async function eventBacklog(decision: string): Promise<EventDTO[]> {
+    const response: Response = await fetch('/api/v1/events/backlog?decision=' + encodeURIComponent(decision), {
+        headers: {
+            'Accept': 'application/json',
+        },
+    })
+
+    if (!response.ok) {
+        return []
+    }
+
+    const data: any[] = await response.json()
+    return data.map(e => new EventDTO(e))
+}
+
+// rules gets all allow and deny rules
+export async function rules(): Promise<RuleDTO[]> {
+    const response: Response = await fetch('/api/v1/rules', {
+        headers: {
+            'Accept': 'application/json',
+        },
+    })
+
+    if (!response.ok) {
+        return []
+    }
+
+    const data: any[] = await response.json()
+    return data.map(r => new RuleDTO(r))
+}
+
+// putRule creates or replaces a rule and returns error message or null on success
+export async function putRule(dto: RuleDTO): Promise<string> {
+    const response: Response = await fetch('/api/v1/rules', {
+        method: 'PUT',
+        headers: {
+            'Accept': 'application/json',
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(dto)
+    })
+
+    if (response.ok) {
+        return null
+    }
+
+    return response.status + ' ' + response.statusText
+}
+
+// revokeRules removes rules and returns error message or null on success
+export async function revokeRules(keys: RuleKeyDTO[]): Promise<string> {
+    const response: Response = await fetch('/api/v1/rules/revoke', {
+        method: 'POST',
+        headers: {
+            'Accept': 'application/json',
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(new RevokeDTO({rules: keys}))
+    })
+
+    if (response.ok) {
+        return null
+    }
+
+    return response.status + ' ' + response.statusText
+}
@@ -3,9 +3,221 @@
 
 export class AllowDTO {
     fqdn: string;
+    types: string[];
+    scope: string;
+    expires: string;
+    comment: string;
 
     constructor(source: any = {}) {
         if ('string' === typeof source) source = JSON.parse(source);
         this.fqdn = source["fqdn"];
+        this.types = source["types"];
+        this.scope = source["scope"];
+        this.expires = source["expires"];
+        this.comment = source["comment"];
+    }
+}
+export class ResponseDTO {
+    msg: string;
+
+    constructor(source: any = {}) {
+        if ('string' === typeof source) source = JSON.parse(source);
+        this.msg = source["msg"];
+    }
+}
+export class FieldErrorDTO {
+    field: string;
+    msg: string;
+
+    constructor(source: any = {}) {
+        if ('string' === typeof source) source = JSON.parse(source);
+        this.field = source["field"];
+        this.msg = source["msg"];
+    }
+}
+export class ErrorsDTO {
+    errors: FieldErrorDTO[];
+
+    constructor(source: any = {}) {
+        if ('string' === typeof source) source = JSON.parse(source);
+        this.errors = this.convertValues(source["errors"], FieldErrorDTO);
+    }
+
+	convertValues(a: any, classs: any, asMap: boolean = false): any {
+	    if (!a) {
+	        return a;
+	    }
+	    if (a.slice) {
+	        return (a as any[]).map(elem => this.convertValues(elem, classs));
+	    } else if ("object" === typeof a) {
+	        if (asMap) {
+	            for (const key of Object.keys(a)) {
+	                a[key] = new classs(a[key]);
+	            }
+	            return a;
+	        }
+	        return new classs(a);
+	    }
+	    return a;
+	}
+}
+export class RuleDTO {
+    fqdn: string;
+    type: string;
+    action: string;
+    scope: string;
+    expires: string;
+    comment: string;
+    block_mode: string;
+    hits: number;
+    last_seen: string;
+
+    constructor(source: any = {}) {
+        if ('string' === typeof source) source = JSON.parse(source);
+        this.fqdn = source["fqdn"];
+        this.type = source["type"];
+        this.action = source["action"];
+        this.scope = source["scope"];
+        this.expires = source["expires"];
+        this.comment = source["comment"];
+        this.block_mode = source["block_mode"];
+        this.hits = source["hits"];
+        this.last_seen = source["last_seen"];
+    }
+}
+export class LocalRecordDTO {
+    record: string;
+    file: string;
+
+    constructor(source: any = {}) {
+        if ('string' === typeof source) source = JSON.parse(source);
+        this.record = source["record"];
+        this.file = source["file"];
+    }
+}
+export class RuleKeyDTO {
+    fqdn: string;
+    type: string;
+    action: string;
+
+    constructor(source: any = {}) {
+        if ('string' === typeof source) source = JSON.parse(source);
+        this.fqdn = source["fqdn"];
+        this.type = source["type"];
+        this.action = source["action"];
+    }
+}
+export class RevokeDTO {
+    rules: RuleKeyDTO[];
+
+    constructor(source: any = {}) {
+        if ('string' === typeof source) source = JSON.parse(source);
+        this.rules = this.convertValues(source["rules"], RuleKeyDTO);
+    }
+
+	convertValues(a: any, classs: any, asMap: boolean = false): any {
+	    if (!a) {
+	        return a;
+	    }
+	    if (a.slice) {
+	        return (a as any[]).map(elem => this.convertValues(elem, classs));
+	    } else if ("object" === typeof a) {
+	        if (asMap) {
+	            for (const key of Object.keys(a)) {
+	                a[key] = new classs(a[key]);
+	            }
+	            return a;
+	        }
+	        return new classs(a);
+	    }
+	    return a;
+	}
+}
+export class CountDTO {
+    name: string;
+    label: string;
+    count: number;
+
+    constructor(source: any = {}) {
+        if ('string' === typeof source) source = JSON.parse(source);
+        this.name = source["name"];
+        this.label = source["label"];
+        this.count = source["count"];
+    }
+}
+export class StatsDTO {
+    window: string;
+    total: number;
+    allowed: number;
+    blocked: number;
+    dropped: number;
+    slipped: number;
+    top_queried: CountDTO[];
+    top_blocked: CountDTO[];
+    top_clients: CountDTO[];
+    top_limited: CountDTO[];
+    qtypes: CountDTO[];
+    latency_p50: number;
+    latency_p90: number;
+    latency_p99: number;
+
+    constructor(source: any = {}) {
+        if ('string' === typeof source) source = JSON.parse(source);
+        this.window = source["window"];
+        this.total = source["total"];
+        this.allowed = source["allowed"];
+        this.blocked = source["blocked"];
+        this.dropped = source["dropped"];
+        this.slipped = source["slipped"];
+        this.top_queried = this.convertValues(source["top_queried"], CountDTO);
+        this.top_blocked = this.convertValues(source["top_blocked"], CountDTO);
+        this.top_clients = this.convertValues(source["top_clients"], CountDTO);
+        this.top_limited = this.convertValues(source["top_limited"], CountDTO);
+        this.qtypes = this.convertValues(source["qtypes"], CountDTO);
+        this.latency_p50 = source["latency_p50"];
+        this.latency_p90 = source["latency_p90"];
+        this.latency_p99 = source["latency_p99"];
+    }
+
+	convertValues(a: any, classs: any, asMap: boolean = false): any {
+	    if (!a) {
+	        return a;
+	    }
+	    if (a.slice) {
+	        return (a as any[]).map(elem => this.convertValues(elem, classs));
+	    } else if ("object" === typeof a) {
+	        if (asMap) {
+	            for (const key of Object.keys(a)) {
+	                a[key] = new classs(a[key]);
+	            }
+	            return a;
+	        }
+	        return new classs(a);
+	    }
+	    return a;
+	}
+}
+export class EventDTO {
+    time: string;
+    client: string;
+    hostname: string;
+    name: string;
+    type: string;
+    decision: string;
+    reason: string;
+    rule: string;
+    ip: string;
+
+    constructor(source: any = {}) {
+        if ('string' === typeof source) source = JSON.parse(source);
+        this.time = source["time"];
+        this.client = source["client"];
+        this.hostname = source["hostname"];
+        this.name = source["name"];
+        this.type = source["type"];
+        this.decision = source["decision"];
+        this.reason = source["reason"];
+        this.rule = source["rule"];
+        this.ip = source["ip"];
     }
 }
\ No newline at end of file
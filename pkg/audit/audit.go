@@ -0,0 +1,252 @@
+package audit
+
+/*
+Append-only audit trail of allowlist changes stored as JSON lines.
+Files are rotated by size and rotated files are never removed.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ActionAllow  = `allow`
+	ActionDeny   = `deny`
+	ActionRevoke = `revoke`
+
+	ActionLocalAdd    = `local-add`    // Local record added
+	ActionLocalRemove = `local-remove` // Local record removed
+)
+
+// Entry is a single change made to the rules
+type Entry struct {
+	Time    time.Time       `json:"time"`
+	Actor   string          `json:"actor"`  // Who made the change
+	Source  string          `json:"source"` // IP address the change came from
+	Action  string          `json:"action"`
+	FQDN    string          `json:"fqdn"`
+	Types   []string        `json:"types"` // Record types
+	Subtree bool            `json:"subtree,omitempty"`
+	Expires *time.Time      `json:"expires,omitempty"`
+	Comment string          `json:"comment,omitempty"`
+	Prior   map[string]bool `json:"prior"`            // Record type -> allowed before the change
+	Record  string          `json:"record,omitempty"` // Local record in zone file format
+}
+
+// Filter limits entries returned by Log.Find. Empty fields match everything.
+type Filter struct {
+	Actor  string
+	Source string
+	Action string
+	FQDN   string // Substring
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+func (f Filter) match(e Entry) bool {
+	if f.Actor != `` && f.Actor != e.Actor {
+		return false
+	}
+
+	if f.Source != `` && f.Source != e.Source {
+		return false
+	}
+
+	if f.Action != `` && f.Action != e.Action {
+		return false
+	}
+
+	if f.FQDN != `` && !strings.Contains(e.FQDN, f.FQDN) {
+		return false
+	}
+
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+
+	return true
+}
+
+type Log struct {
+	path    string
+	maxSize int64 // Bytes before current file is rotated, 0 never rotates
+	mu      sync.Mutex
+}
+
+// New opens audit trail file p. It's rotated when it grows over maxSize bytes, 0 never rotates.
+func New(p string, maxSize int64) (*Log, error) {
+	// Make sure the file can be created and written to
+	fh, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fh.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Log{
+		path:    p,
+		maxSize: maxSize,
+	}, nil
+}
+
+// filePath returns path of n:th file, 0 is the current file. audit.jsonl is rotated to audit.1.jsonl.
+func (l *Log) filePath(n int) string {
+	if n == 0 {
+		return l.path
+	}
+
+	ext := path.Ext(l.path)
+
+	return fmt.Sprintf(`%s.%d%s`, strings.TrimSuffix(l.path, ext), n, ext)
+}
+
+// rotated counts rotated files
+func (l *Log) rotated() (n int, err error) {
+	for {
+		_, err = os.Stat(l.filePath(n + 1))
+		if errors.Is(err, os.ErrNotExist) {
+			return n, nil
+		}
+
+		if err != nil {
+			return n, err
+		}
+
+		n++
+	}
+}
+
+// rotate renames rotated files one number up and the current file to the first rotated file
+func (l *Log) rotate() error {
+	n, err := l.rotated()
+	if err != nil {
+		return err
+	}
+
+	for i := n; i >= 0; i-- {
+		err = os.Rename(l.filePath(i), l.filePath(i+1))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Append writes entry at the end of the log
+func (l *Log) Append(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxSize > 0 {
+		fi, err := os.Stat(l.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		if err == nil && fi.Size() > 0 && fi.Size()+int64(len(b))+1 > l.maxSize {
+			err = l.rotate()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	fh, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	_, err = fh.Write(append(b, '\n'))
+	if err != nil {
+		return err
+	}
+
+	return fh.Sync()
+}
+
+// Find returns entries matching the filter from the current and rotated files, newest first
+func (l *Log) Find(f Filter) (entries []Entry, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n, err := l.rotated()
+	if err != nil {
+		return nil, err
+	}
+
+	// Oldest file first
+	for i := n; i >= 0; i-- {
+		entries, err = readFile(l.filePath(i), f, entries)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	if f.Limit > 0 && len(entries) > f.Limit {
+		entries = entries[:f.Limit]
+	}
+
+	return entries, nil
+}
+
+// readFile appends entries of file p matching the filter to entries
+func readFile(p string, f Filter, entries []Entry) ([]Entry, error) {
+	fh, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return entries, nil
+		}
+
+		return nil, err
+	}
+	defer fh.Close()
+
+	sc := bufio.NewScanner(fh)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for sc.Scan() {
+		var e Entry
+
+		err = json.Unmarshal(sc.Bytes(), &e)
+		if err != nil {
+			return nil, fmt.Errorf(`%s: %w`, p, err)
+		}
+
+		if f.match(e) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, sc.Err()
+}
@@ -0,0 +1,218 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func newTestLog(t *testing.T, maxSize int64) *Log {
+	t.Helper()
+
+	l, err := New(path.Join(t.TempDir(), `audit.jsonl`), maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+func TestAppend(t *testing.T) {
+	l := newTestLog(t, 0)
+	expires := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	e := Entry{
+		Actor:   `alice`,
+		Source:  `192.0.2.1`,
+		Action:  ActionAllow,
+		FQDN:    `example.com`,
+		Types:   []string{`A`, `AAAA`},
+		Subtree: true,
+		Expires: &expires,
+		Comment: `test`,
+		Prior:   map[string]bool{`A`: false, `AAAA`: true},
+	}
+
+	if err := l.Append(e); err != nil {
+		t.Fatal(err)
+	}
+
+	fh, err := os.Open(l.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	// One JSON object per line
+	var lines []Entry
+
+	sc := bufio.NewScanner(fh)
+	for sc.Scan() {
+		var got Entry
+
+		err = json.Unmarshal(sc.Bytes(), &got)
+		if err != nil {
+			t.Fatalf(`line %q: %v`, sc.Text(), err)
+		}
+
+		lines = append(lines, got)
+	}
+
+	if len(lines) != 1 {
+		t.Fatalf(`got %d lines, want 1`, len(lines))
+	}
+
+	got := lines[0]
+
+	if got.Time.IsZero() {
+		t.Fatal(`time not set`)
+	}
+
+	if got.Actor != e.Actor || got.Source != e.Source || got.Action != e.Action || got.FQDN != e.FQDN ||
+		len(got.Types) != 2 || !got.Subtree || !got.Expires.Equal(expires) || got.Comment != e.Comment ||
+		got.Prior[`A`] || !got.Prior[`AAAA`] {
+		t.Fatalf(`got %+v, want %+v`, got, e)
+	}
+}
+
+func TestFind(t *testing.T) {
+	l := newTestLog(t, 0)
+	start := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	entries := []Entry{
+		{Time: start, Actor: `alice`, Source: `192.0.2.1`, Action: ActionAllow, FQDN: `example.com`},
+		{Time: start.Add(time.Minute), Actor: `bob`, Source: `192.0.2.2`, Action: ActionDeny, FQDN: `ads.example.com`},
+		{Time: start.Add(2 * time.Minute), Actor: `alice`, Source: `192.0.2.1`, Action: ActionRevoke, FQDN: `example.org`},
+		{Time: start.Add(3 * time.Minute), Actor: `alice`, Source: `192.0.2.3`, Action: ActionLocalAdd, FQDN: `nas.lan`},
+	}
+
+	for _, e := range entries {
+		if err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string // FQDNs, newest first
+	}{
+		{`all`, Filter{}, []string{`nas.lan`, `example.org`, `ads.example.com`, `example.com`}},
+		{`actor`, Filter{Actor: `bob`}, []string{`ads.example.com`}},
+		{`source`, Filter{Source: `192.0.2.1`}, []string{`example.org`, `example.com`}},
+		{`action`, Filter{Action: ActionRevoke}, []string{`example.org`}},
+		{`name substring`, Filter{FQDN: `example.com`}, []string{`ads.example.com`, `example.com`}},
+		{`since`, Filter{Since: start.Add(2 * time.Minute)}, []string{`nas.lan`, `example.org`}},
+		{`until`, Filter{Until: start.Add(time.Minute)}, []string{`ads.example.com`, `example.com`}},
+		{`limit`, Filter{Actor: `alice`, Limit: 2}, []string{`nas.lan`, `example.org`}},
+		{`no match`, Filter{Actor: `mallory`}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := l.Find(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, e := range found {
+				got = append(got, e.FQDN)
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf(`got %q, want %q`, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotate(t *testing.T) {
+	// Room for about two entries per file
+	l := newTestLog(t, 300)
+
+	for i := 0; i < 10; i++ {
+		err := l.Append(Entry{Actor: `alice`, Action: ActionAllow, FQDN: fmt.Sprintf(`host%d.example.com`, i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := l.rotated()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n < 3 {
+		t.Fatalf(`got %d rotated files`, n)
+	}
+
+	for i := 0; i <= n; i++ {
+		fi, err := os.Stat(l.filePath(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fi.Size() > l.maxSize {
+			t.Fatalf(`file %d has %d bytes, more than %d`, i, fi.Size(), l.maxSize)
+		}
+	}
+
+	// Nothing is lost and entries are read back newest first across files
+	found, err := l.Find(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != 10 {
+		t.Fatalf(`got %d entries, want 10`, len(found))
+	}
+
+	for i, e := range found {
+		if want := fmt.Sprintf(`host%d.example.com`, 9-i); e.FQDN != want {
+			t.Fatalf(`entry %d: got %s, want %s`, i, e.FQDN, want)
+		}
+	}
+
+	// Reopening continues the same files
+	l2, err := New(l.path, l.maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err = l2.Find(Filter{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != 1 || found[0].FQDN != `host9.example.com` {
+		t.Fatalf(`got %+v after reopen`, found)
+	}
+}
+
+func TestFilePath(t *testing.T) {
+	tests := []struct {
+		path string
+		n    int
+		want string
+	}{
+		{`/var/torjuja/audit.jsonl`, 0, `/var/torjuja/audit.jsonl`},
+		{`/var/torjuja/audit.jsonl`, 1, `/var/torjuja/audit.1.jsonl`},
+		{`/var/torjuja/audit.jsonl`, 12, `/var/torjuja/audit.12.jsonl`},
+		{`/var/log.d/audit`, 2, `/var/log.d/audit.2`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			l := &Log{path: tt.path}
+
+			if got := l.filePath(tt.n); got != tt.want {
+				t.Fatalf(`got %q, want %q`, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package db
+
+/*
+Registry of storage backends. Backend packages register themselves in init,
+so importing a backend makes it selectable in the database configuration.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"sort"
+	"sync"
+)
+
+// Opener opens a backend with its configuration block, nil if the configuration has no block for the backend
+type Opener func(cfg json.RawMessage) (iface.Database, error)
+
+var (
+	mu       sync.Mutex
+	backends = make(map[string]Opener)
+)
+
+// Register makes backend available by name. Registering the same name twice panics.
+func Register(name string, open Opener) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf(`database backend %q registered twice`, name))
+	}
+
+	backends[name] = open
+}
+
+// Open opens backend name with its configuration block
+func Open(name string, cfg json.RawMessage) (iface.Database, error) {
+	mu.Lock()
+	open, ok := backends[name]
+	mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf(`unknown database backend %q, available: %v`, name, Backends())
+	}
+
+	d, err := open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf(`database backend %s: %w`, name, err)
+	}
+
+	return d, nil
+}
+
+// Backends lists registered backend names
+func Backends() (names []string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for name := range backends {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
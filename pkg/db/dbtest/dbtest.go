@@ -0,0 +1,436 @@
+package dbtest
+
+/*
+Conformance test suite every iface.Database implementation must pass.
+Backends call Run from their own tests.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"os"
+	"testing"
+	"time"
+)
+
+var checks = []struct {
+	name string
+	fn   func(d iface.Database) error
+}{
+	{`empty`, checkEmpty},
+	{`allow exact`, checkAllowExact},
+	{`rule types`, checkRuleTypes},
+	{`allow subtree`, checkAllowSubtree},
+	{`deny wins on same name`, checkDenyWins},
+	{`most specific wins`, checkMostSpecific},
+	{`expired rules don't match`, checkExpired},
+	{`options round trip`, checkOptions},
+	{`replace rule`, checkReplace},
+	{`rules list`, checkRules},
+	{`revoke`, checkRevoke},
+	{`hits`, checkHits},
+	{`creation time`, checkCreated},
+}
+
+// Run runs the conformance suite as subtests. open must return a new empty database on every call.
+func Run(t *testing.T, open func(t *testing.T) iface.Database) {
+	for _, c := range checks {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.fn(open(t)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// expectAllowed checks AllowedType of name and record type t
+func expectAllowed(d iface.Database, name string, t string, want bool) error {
+	got, err := d.AllowedType(name, t)
+	if err != nil {
+		return err
+	}
+
+	if got != want {
+		return fmt.Errorf(`%s %s: allowed %v, want %v`, t, name, got, want)
+	}
+
+	return nil
+}
+
+// expectMatch checks action of the rule matching name, empty action for no match
+func expectMatch(d iface.Database, name string, t string, rule string, action string) error {
+	r, ok, err := d.Match(name, t)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		if action != `` {
+			return fmt.Errorf(`%s %s: no match, want %s %s`, t, name, action, rule)
+		}
+
+		return nil
+	}
+
+	if r.Action != action || r.Name != rule {
+		return fmt.Errorf(`%s %s: matched %s %s, want %q %q`, t, name, r.Action, r.Name, action, rule)
+	}
+
+	return nil
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkEmpty(d iface.Database) error {
+	rules, err := d.Rules()
+	if err != nil {
+		return err
+	}
+
+	if len(rules) != 0 {
+		return fmt.Errorf(`%d rules in new database`, len(rules))
+	}
+
+	return firstError(
+		expectAllowed(d, `example.com`, `A`, false),
+		expectMatch(d, `example.com`, `A`, ``, ``),
+	)
+}
+
+func checkAllowExact(d iface.Database) error {
+	if err := d.AllowA(`example.com`); err != nil {
+		return err
+	}
+
+	a, err := d.AllowedA(`example.com`)
+	if err != nil {
+		return err
+	}
+
+	if !a {
+		return fmt.Errorf(`AllowedA: false after AllowA`)
+	}
+
+	return firstError(
+		expectAllowed(d, `www.example.com`, `A`, false),
+		expectAllowed(d, `com`, `A`, false),
+		expectMatch(d, `example.com`, `A`, `example.com`, iface.ActionAllow),
+	)
+}
+
+func checkRuleTypes(d iface.Database) error {
+	if err := d.AllowAAAA(`example.com`); err != nil {
+		return err
+	}
+
+	if err := d.Allow(`example.com`, `TXT`, iface.RuleOptions{}); err != nil {
+		return err
+	}
+
+	ptr, err := d.AllowedPTR(`example.com`)
+	if err != nil {
+		return err
+	}
+
+	if ptr {
+		return fmt.Errorf(`AllowedPTR: true without PTR rule`)
+	}
+
+	// A, AAAA, HTTPS and SVCB share a rule
+	return firstError(
+		expectAllowed(d, `example.com`, `A`, true),
+		expectAllowed(d, `example.com`, `HTTPS`, true),
+		expectAllowed(d, `example.com`, `SVCB`, true),
+		expectAllowed(d, `example.com`, `TXT`, true),
+		expectAllowed(d, `example.com`, `MX`, false),
+	)
+}
+
+func checkAllowSubtree(d iface.Database) error {
+	if err := d.Allow(`example.org`, `A`, iface.RuleOptions{Subtree: true}); err != nil {
+		return err
+	}
+
+	return firstError(
+		expectAllowed(d, `example.org`, `A`, true),
+		expectAllowed(d, `www.example.org`, `A`, true),
+		expectAllowed(d, `a.b.example.org`, `A`, true),
+		expectAllowed(d, `notexample.org`, `A`, false),
+		expectMatch(d, `www.example.org`, `A`, `example.org`, iface.ActionAllow),
+	)
+}
+
+func checkDenyWins(d iface.Database) error {
+	if err := d.AllowA(`example.com`); err != nil {
+		return err
+	}
+
+	if err := d.Deny(`example.com`, `A`, iface.RuleOptions{}); err != nil {
+		return err
+	}
+
+	return firstError(
+		expectAllowed(d, `example.com`, `A`, false),
+		expectMatch(d, `example.com`, `A`, `example.com`, iface.ActionDeny),
+	)
+}
+
+func checkMostSpecific(d iface.Database) error {
+	if err := d.Deny(`example.net`, `A`, iface.RuleOptions{Subtree: true}); err != nil {
+		return err
+	}
+
+	if err := d.AllowA(`www.example.net`); err != nil {
+		return err
+	}
+
+	return firstError(
+		expectAllowed(d, `www.example.net`, `A`, true),
+		expectAllowed(d, `other.example.net`, `A`, false),
+		expectMatch(d, `other.example.net`, `A`, `example.net`, iface.ActionDeny),
+		expectMatch(d, `www.example.net`, `A`, `www.example.net`, iface.ActionAllow),
+	)
+}
+
+func checkExpired(d iface.Database) error {
+	past := time.Now().Add(-time.Hour)
+
+	if err := d.Allow(`example.com`, `A`, iface.RuleOptions{Expires: past}); err != nil {
+		return err
+	}
+
+	_, ok, err := d.Rule(`example.com`, `A`, iface.ActionAllow)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf(`expired rule not found with Rule`)
+	}
+
+	return firstError(
+		expectAllowed(d, `example.com`, `A`, false),
+		expectMatch(d, `example.com`, `A`, ``, ``),
+	)
+}
+
+func checkOptions(d iface.Database) error {
+	opts := iface.RuleOptions{
+		Subtree:   true,
+		Expires:   time.Now().Add(time.Hour).Truncate(time.Second),
+		Comment:   `conformance`,
+		BlockMode: iface.BlockModeNXDomain,
+	}
+
+	if err := d.Deny(`example.com`, `AAAA`, opts); err != nil {
+		return err
+	}
+
+	r, ok, err := d.Rule(`example.com`, `A`, iface.ActionDeny)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf(`rule not found`)
+	}
+
+	if r.Name != `example.com` || r.Type != iface.TypeIP || r.Action != iface.ActionDeny {
+		return fmt.Errorf(`got rule %s %s %s`, r.Action, r.Type, r.Name)
+	}
+
+	if r.Subtree != opts.Subtree || r.Comment != opts.Comment || r.BlockMode != opts.BlockMode || !r.Expires.Equal(opts.Expires) {
+		return fmt.Errorf(`got options %+v, want %+v`, r.RuleOptions, opts)
+	}
+
+	return nil
+}
+
+func checkReplace(d iface.Database) error {
+	if err := d.Allow(`example.com`, `A`, iface.RuleOptions{Subtree: true, Comment: `first`}); err != nil {
+		return err
+	}
+
+	if err := d.Allow(`example.com`, `A`, iface.RuleOptions{Comment: `second`}); err != nil {
+		return err
+	}
+
+	r, _, err := d.Rule(`example.com`, `A`, iface.ActionAllow)
+	if err != nil {
+		return err
+	}
+
+	if r.Comment != `second` || r.Subtree {
+		return fmt.Errorf(`got options %+v after replace`, r.RuleOptions)
+	}
+
+	return expectAllowed(d, `www.example.com`, `A`, false)
+}
+
+func checkRules(d iface.Database) error {
+	if err := d.AllowA(`example.com`); err != nil {
+		return err
+	}
+
+	if err := d.Deny(`ads.example.com`, `A`, iface.RuleOptions{}); err != nil {
+		return err
+	}
+
+	if err := d.Allow(`example.com`, `TXT`, iface.RuleOptions{}); err != nil {
+		return err
+	}
+
+	rules, err := d.Rules()
+	if err != nil {
+		return err
+	}
+
+	want := map[string]bool{
+		iface.ActionAllow + ` IP example.com`:    true,
+		iface.ActionDeny + ` IP ads.example.com`: true,
+		iface.ActionAllow + ` TXT example.com`:   true,
+	}
+
+	if len(rules) != len(want) {
+		return fmt.Errorf(`got %d rules, want %d`, len(rules), len(want))
+	}
+
+	for _, r := range rules {
+		k := r.Action + ` ` + r.Type + ` ` + r.Name
+		if !want[k] {
+			return fmt.Errorf(`unexpected rule %s`, k)
+		}
+	}
+
+	return nil
+}
+
+func checkRevoke(d iface.Database) error {
+	if err := d.AllowA(`example.com`); err != nil {
+		return err
+	}
+
+	if err := d.Deny(`example.com`, `A`, iface.RuleOptions{}); err != nil {
+		return err
+	}
+
+	if err := d.Revoke(`example.com`, `A`, iface.ActionDeny); err != nil {
+		return err
+	}
+
+	if err := expectAllowed(d, `example.com`, `A`, true); err != nil {
+		return fmt.Errorf(`allow rule after revoking deny: %w`, err)
+	}
+
+	if err := d.Revoke(`example.com`, `AAAA`, iface.ActionAllow); err != nil {
+		return err
+	}
+
+	if err := expectAllowed(d, `example.com`, `A`, false); err != nil {
+		return err
+	}
+
+	err := d.Revoke(`example.com`, `A`, iface.ActionAllow)
+	if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf(`revoking missing rule: got %v, want os.ErrNotExist`, err)
+	}
+
+	if d.Revoke(`example.com`, `A`, `bogus`) == nil {
+		return fmt.Errorf(`revoking unknown action didn't fail`)
+	}
+
+	rules, err := d.Rules()
+	if err != nil {
+		return err
+	}
+
+	if len(rules) != 0 {
+		return fmt.Errorf(`%d rules left after revoking all`, len(rules))
+	}
+
+	return nil
+}
+
+func checkHits(d iface.Database) error {
+	if err := d.Allow(`example.com`, `A`, iface.RuleOptions{Subtree: true}); err != nil {
+		return err
+	}
+
+	if err := d.Deny(`ads.example.com`, `A`, iface.RuleOptions{}); err != nil {
+		return err
+	}
+
+	seen := time.Now().Truncate(time.Second)
+
+	err := d.RecordHits([]iface.Hit{
+		{Name: `www.example.com`, Type: `A`, Count: 2, LastSeen: seen.Add(-time.Minute)},
+		{Name: `example.com`, Type: `AAAA`, Count: 3, LastSeen: seen},
+		{Name: `ads.example.com`, Type: `A`, Count: 5, LastSeen: seen},
+		{Name: `unknown.org`, Type: `A`, Count: 7, LastSeen: seen},
+	})
+	if err != nil {
+		return err
+	}
+
+	r, _, err := d.Rule(`example.com`, `A`, iface.ActionAllow)
+	if err != nil {
+		return err
+	}
+
+	if r.Hits != 5 || !r.LastSeen.Equal(seen) {
+		return fmt.Errorf(`got %d hits last seen %v, want 5 hits last seen %v`, r.Hits, r.LastSeen, seen)
+	}
+
+	r, _, err = d.Rule(`ads.example.com`, `A`, iface.ActionDeny)
+	if err != nil {
+		return err
+	}
+
+	if r.Hits != 0 {
+		return fmt.Errorf(`deny rule has %d hits`, r.Hits)
+	}
+
+	return nil
+}
+
+func checkCreated(d iface.Database) error {
+	// File systems store modification times with coarse precision
+	before := time.Now().Add(-2 * time.Second)
+
+	if err := d.Allow(`example.com`, `A`, iface.RuleOptions{}); err != nil {
+		return err
+	}
+
+	after := time.Now().Add(2 * time.Second)
+
+	r, _, err := d.Rule(`example.com`, `A`, iface.ActionAllow)
+	if err != nil {
+		return err
+	}
+
+	if r.Created.Before(before) || r.Created.After(after) {
+		return fmt.Errorf(`got creation time %v, want between %v and %v`, r.Created, before, after)
+	}
+
+	rules, err := d.Rules()
+	if err != nil {
+		return err
+	}
+
+	if len(rules) != 1 || !rules[0].Created.Equal(r.Created) {
+		return fmt.Errorf(`got rules %+v, want creation time %v`, rules, r.Created)
+	}
+
+	return nil
+}
@@ -5,23 +5,61 @@ Filesystem based database
 */
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/raspi/torjuja/pkg/db"
 	"github.com/raspi/torjuja/pkg/db/iface"
+	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Check implementation
 var _ iface.Database = FileSystemDB{}
 
+func init() {
+	db.Register(`fs`, open)
+}
+
+// Config is the configuration block of backend fs
+type Config struct {
+	Path string `json:"path"` // Absolute path of an existing directory
+}
+
+func open(cfg json.RawMessage) (iface.Database, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf(`no configuration`)
+	}
+
+	var c Config
+
+	err := json.Unmarshal(cfg, &c)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(c.Path)
+}
+
 type FileSystemDB struct {
 	basepath          string
 	allowedPath       string
+	hitsPath          string      // Hit counters of all rules in one file
+	hitsLock          *sync.Mutex // Guards hitsPath
 	defaultPermission os.FileMode
 }
 
+// hit is the stored hit counter of a rule
+type hit struct {
+	Count    uint64    `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
 func New(basepath string) (*FileSystemDB, error) {
 	if !path.IsAbs(basepath) {
 		return nil, fmt.Errorf(`not absolute path: %q`, basepath)
@@ -30,6 +68,8 @@ func New(basepath string) (*FileSystemDB, error) {
 	return &FileSystemDB{
 		basepath:          basepath,
 		allowedPath:       path.Join(basepath, `allowed`),
+		hitsPath:          path.Join(basepath, `hits.json`),
+		hitsLock:          &sync.Mutex{},
 		defaultPermission: 0660,
 	}, nil
 }
@@ -38,23 +78,82 @@ func (f FileSystemDB) getPath(name string, t string) string {
 	return path.Join(f.allowedPath, t, strings.Join(reverse(strings.Split(name, `.`)), string(os.PathSeparator)))
 }
 
-func (f FileSystemDB) allowed(name string, t string) (bool, error) {
-	switch t {
-	case `A`, `AAAA`:
-		t = `IP`
+// ruleMeta is the content of a rule file. Empty file is a permanent rule matching only the exact name.
+type ruleMeta struct {
+	Subtree   bool       `json:"subtree,omitempty"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	Comment   string     `json:"comment,omitempty"`
+	BlockMode string     `json:"block_mode,omitempty"`
+}
+
+func (m ruleMeta) options() (o iface.RuleOptions) {
+	o.Subtree = m.Subtree
+	o.Comment = m.Comment
+	o.BlockMode = m.BlockMode
+
+	if m.Expires != nil {
+		o.Expires = *m.Expires
 	}
 
-	fi, err := os.Stat(path.Join(f.getPath(name, t), `allow`))
+	return o
+}
 
+// readRule reads rule file of name. ok is false if there's no rule.
+func (f FileSystemDB) readRule(name string, t string, action string) (meta ruleMeta, ok bool, err error) {
+	b, err := os.ReadFile(path.Join(f.getPath(name, iface.RuleType(t)), action))
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return false, nil
+			return meta, false, nil
 		}
 
-		return false, err
+		return meta, false, err
 	}
 
-	return fi.Mode().IsRegular(), nil
+	if len(b) > 0 {
+		err = json.Unmarshal(b, &meta)
+		if err != nil {
+			return meta, false, fmt.Errorf(`rule %s %s %s: %w`, action, t, name, err)
+		}
+	}
+
+	return meta, true, nil
+}
+
+// match finds the most specific rule matching name.
+// Exact rules are checked first and then subtree rules of parent domains. Deny wins over allow on the same name.
+func (f FileSystemDB) match(name string, t string) (rule string, action string, err error) {
+	now := time.Now()
+	labels := strings.Split(name, `.`)
+
+	for i := range labels {
+		candidate := strings.Join(labels[i:], `.`)
+
+		for _, action := range []string{iface.ActionDeny, iface.ActionAllow} {
+			meta, found, err := f.readRule(candidate, t, action)
+			if err != nil {
+				return ``, ``, err
+			}
+
+			if !found {
+				continue
+			}
+
+			if meta.Expires != nil && now.After(*meta.Expires) {
+				continue
+			}
+
+			if i == 0 || meta.Subtree {
+				return candidate, action, nil
+			}
+		}
+	}
+
+	return ``, ``, nil
+}
+
+func (f FileSystemDB) allowed(name string, t string) (bool, error) {
+	_, action, err := f.match(name, t)
+	return action == iface.ActionAllow, err
 }
 
 func (f FileSystemDB) AllowedA(name string) (bool, error) {
@@ -69,20 +168,20 @@ func (f FileSystemDB) AllowedPTR(name string) (bool, error) {
 	return f.allowed(name, `PTR`)
 }
 
-func (f FileSystemDB) allow(name string, t string) error {
-	switch t {
-	case `A`, `AAAA`:
-		t = `IP`
-	}
+func (f FileSystemDB) AllowedType(name string, t string) (bool, error) {
+	return f.allowed(name, t)
+}
 
-	fpath := f.getPath(name, t)
+// write creates or replaces rule file
+func (f FileSystemDB) write(name string, t string, action string, opts iface.RuleOptions) error {
+	fpath := f.getPath(name, iface.RuleType(t))
 
 	err := os.MkdirAll(fpath, f.defaultPermission)
 	if err != nil {
 		return err
 	}
 
-	fh, err := os.Create(path.Join(fpath, `allow`))
+	fh, err := os.Create(path.Join(fpath, action))
 	if err != nil {
 		return err
 	}
@@ -93,19 +192,267 @@ func (f FileSystemDB) allow(name string, t string) error {
 		return err
 	}
 
-	return nil
+	if opts == (iface.RuleOptions{}) {
+		return nil
+	}
+
+	meta := ruleMeta{
+		Subtree:   opts.Subtree,
+		Comment:   opts.Comment,
+		BlockMode: opts.BlockMode,
+	}
+
+	if !opts.Expires.IsZero() {
+		meta.Expires = &opts.Expires
+	}
+
+	return json.NewEncoder(fh).Encode(meta)
+}
+
+func (f FileSystemDB) allow(name string, t string, opts iface.RuleOptions) error {
+	return f.write(name, t, iface.ActionAllow, opts)
 }
 
 func (f FileSystemDB) AllowA(name string) error {
-	return f.allow(name, `A`)
+	return f.allow(name, `A`, iface.RuleOptions{})
 }
 
 func (f FileSystemDB) AllowAAAA(name string) error {
-	return f.allow(name, `AAAA`)
+	return f.allow(name, `AAAA`, iface.RuleOptions{})
 }
 
 func (f FileSystemDB) AllowPTR(name string) error {
-	return f.allow(name, `PTR`)
+	return f.allow(name, `PTR`, iface.RuleOptions{})
+}
+
+func (f FileSystemDB) Allow(name string, t string, opts iface.RuleOptions) error {
+	return f.allow(name, t, opts)
+}
+
+func (f FileSystemDB) Deny(name string, t string, opts iface.RuleOptions) error {
+	return f.write(name, t, iface.ActionDeny, opts)
+}
+
+// Revoke removes rule and the directories left empty
+func (f FileSystemDB) Revoke(name string, t string, action string) error {
+	switch action {
+	case iface.ActionAllow, iface.ActionDeny:
+	default:
+		return fmt.Errorf(`unknown action %q`, action)
+	}
+
+	t = iface.RuleType(t)
+	fpath := f.getPath(name, t)
+
+	err := os.Remove(path.Join(fpath, action))
+	if err != nil {
+		return err
+	}
+
+	root := path.Join(f.allowedPath, t)
+
+	for fpath != root {
+		if os.Remove(fpath) != nil {
+			// Not empty
+			break
+		}
+
+		fpath = path.Dir(fpath)
+	}
+
+	return nil
+}
+
+// Match gets the rule deciding query of name, ok is false if no rule matches
+func (f FileSystemDB) Match(name string, t string) (r iface.Rule, ok bool, err error) {
+	rule, action, err := f.match(name, t)
+	if err != nil || action == `` {
+		return r, false, err
+	}
+
+	return f.Rule(rule, t, action)
+}
+
+// Rule gets a single rule, ok is false if it doesn't exist
+func (f FileSystemDB) Rule(name string, t string, action string) (r iface.Rule, ok bool, err error) {
+	meta, ok, err := f.readRule(name, t, action)
+	if err != nil || !ok {
+		return r, ok, err
+	}
+
+	// Rule files are rewritten only when the rule is replaced
+	fi, err := os.Stat(path.Join(f.getPath(name, iface.RuleType(t)), action))
+	if err != nil {
+		return r, false, err
+	}
+
+	r = iface.Rule{
+		RuleOptions: meta.options(),
+		Name:        name,
+		Type:        iface.RuleType(t),
+		Action:      action,
+		Created:     fi.ModTime(),
+	}
+
+	if action == iface.ActionAllow {
+		hits, err := f.readHits()
+		if err != nil {
+			return r, false, err
+		}
+
+		if h, ok := hits[hitKey(r.Name, r.Type)]; ok {
+			r.Hits = h.Count
+			r.LastSeen = h.LastSeen
+		}
+	}
+
+	return r, true, nil
+}
+
+// Rules lists all rules with hit counters of allow rules
+func (f FileSystemDB) Rules() (rules []iface.Rule, err error) {
+	hits, err := f.readHits()
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.WalkDir(f.allowedPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		action := d.Name()
+
+		switch action {
+		case iface.ActionAllow, iface.ActionDeny:
+		default:
+			return nil
+		}
+
+		rel, err := filepath.Rel(f.allowedPath, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+
+		parts := strings.Split(rel, string(os.PathSeparator))
+		if len(parts) < 2 {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		r := iface.Rule{
+			Type:    parts[0],
+			Name:    strings.Join(reverse(parts[1:]), `.`),
+			Action:  action,
+			Created: fi.ModTime(),
+		}
+
+		meta, _, err := f.readRule(r.Name, r.Type, action)
+		if err != nil {
+			return err
+		}
+
+		r.RuleOptions = meta.options()
+
+		if h, ok := hits[hitKey(r.Name, r.Type)]; ok && action == iface.ActionAllow {
+			r.Hits = h.Count
+			r.LastSeen = h.LastSeen
+		}
+
+		rules = append(rules, r)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// RecordHits adds batched hit counters to the hits file
+func (f FileSystemDB) RecordHits(batch []iface.Hit) error {
+	f.hitsLock.Lock()
+	defer f.hitsLock.Unlock()
+
+	hits, err := f.readHits()
+	if err != nil {
+		return err
+	}
+
+	for _, h := range batch {
+		// Count hit for the rule that matched, which can be a subtree rule of a parent domain
+		name, action, err := f.match(h.Name, h.Type)
+		if err != nil {
+			return err
+		}
+
+		if action != iface.ActionAllow {
+			continue
+		}
+
+		k := hitKey(name, iface.RuleType(h.Type))
+
+		cur := hits[k]
+		cur.Count += h.Count
+
+		if h.LastSeen.After(cur.LastSeen) {
+			cur.LastSeen = h.LastSeen
+		}
+
+		hits[k] = cur
+	}
+
+	b, err := json.Marshal(hits)
+	if err != nil {
+		return err
+	}
+
+	// Write to temporary file first so that a crash never leaves a truncated file
+	tmp := f.hitsPath + `.tmp`
+
+	err = os.WriteFile(tmp, b, f.defaultPermission)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, f.hitsPath)
+}
+
+func (f FileSystemDB) readHits() (map[string]hit, error) {
+	hits := make(map[string]hit)
+
+	b, err := os.ReadFile(f.hitsPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return hits, nil
+		}
+
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &hits)
+	if err != nil {
+		return nil, err
+	}
+
+	return hits, nil
+}
+
+func hitKey(name string, t string) string {
+	return t + `/` + name
 }
 
 func reverse(s []string) []string {
@@ -0,0 +1,18 @@
+package fsdb
+
+import (
+	"github.com/raspi/torjuja/pkg/db/dbtest"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) iface.Database {
+		d, err := New(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return d
+	})
+}
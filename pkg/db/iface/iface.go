@@ -1,18 +1,87 @@
 package iface
 
+import "time"
+
 type Allowed interface {
 	AllowedA(name string) (bool, error)
 	AllowedAAAA(name string) (bool, error)
 	AllowedPTR(name string) (bool, error)
+	AllowedType(name string, t string) (bool, error) // Any record type, t is for example TXT or SRV
 }
 
 type AllowAPI interface {
 	AllowA(name string) error    // IPv4
 	AllowAAAA(name string) error // IPv6
 	AllowPTR(name string) error  // Reverse
+	Allow(name string, t string, opts RuleOptions) error
+}
+
+type DenyAPI interface {
+	Deny(name string, t string, opts RuleOptions) error
+}
+
+const (
+	ActionAllow = `allow`
+	ActionDeny  = `deny`
+)
+
+// Block modes decide how blocked queries are answered
+const (
+	BlockModeNullIP   = `null-ip`  // Address from configuration for A and AAAA, NODATA for others
+	BlockModeNXDomain = `nxdomain` // Name doesn't exist
+	BlockModeNoData   = `nodata`   // Name exists without records of the type
+	BlockModeRefused  = `refused`
+	BlockModeCustom   = `custom` // Per record type templates from configuration
+)
+
+// ValidBlockMode checks that m is a known block mode
+func ValidBlockMode(m string) bool {
+	switch m {
+	case BlockModeNullIP, BlockModeNXDomain, BlockModeNoData, BlockModeRefused, BlockModeCustom:
+		return true
+	}
+
+	return false
+}
+
+// RuleOptions are optional properties of a rule
+type RuleOptions struct {
+	Subtree   bool      // Rule also matches all subdomains of the name
+	Expires   time.Time // Rule stops matching after this, zero if never
+	Comment   string    // Free text note, for example why the rule was added
+	BlockMode string    // How denied queries are answered, empty for the client or global default
+}
+
+// Rule is a single entry in the database
+type Rule struct {
+	RuleOptions
+	Name     string    // FQDN without trailing dot
+	Type     string    // Rule type, see RuleType
+	Action   string    // ActionAllow or ActionDeny
+	Hits     uint64    // How many times the rule has matched a query
+	LastSeen time.Time // When the rule last matched a query, zero if never
+	Created  time.Time // When the rule was created or last replaced
+}
+
+// Hit is a batch of matches of the rule for record type Type on name Name
+type Hit struct {
+	Name     string
+	Type     string // Record type, for example A or AAAA
+	Count    uint64
+	LastSeen time.Time
+}
+
+type Rules interface {
+	Rules() ([]Rule, error)
+	Rule(name string, t string, action string) (r Rule, ok bool, err error)
+	Match(name string, t string) (r Rule, ok bool, err error) // Rule deciding query of name and record type t
+	Revoke(name string, t string, action string) error
+	RecordHits(hits []Hit) error
 }
 
 type Database interface {
 	Allowed
 	AllowAPI
+	DenyAPI
+	Rules
 }
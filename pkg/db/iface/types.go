@@ -0,0 +1,33 @@
+package iface
+
+// TypeIP is the rule type of record types that resolve a name to addresses
+const TypeIP = `IP`
+
+// typeClasses maps record types to the rule type they are stored as.
+// HTTPS and SVCB carry address hints for the same endpoint so they share the rule with A and AAAA.
+// Types not listed here have a rule of their own.
+var typeClasses = map[string]string{
+	`A`:     TypeIP,
+	`AAAA`:  TypeIP,
+	`HTTPS`: TypeIP,
+	`SVCB`:  TypeIP,
+}
+
+// RuleType gets rule type of record type t
+func RuleType(t string) string {
+	if c, ok := typeClasses[t]; ok {
+		return c
+	}
+
+	return t
+}
+
+// RecordTypes gets record types covered by rule type t
+func RecordTypes(t string) []string {
+	if t != TypeIP {
+		return []string{t}
+	}
+
+	// Ordered for stable output
+	return []string{`A`, `AAAA`, `HTTPS`, `SVCB`}
+}
@@ -0,0 +1,244 @@
+package memdb
+
+/*
+In-memory database. Rules are lost when the process exits.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/raspi/torjuja/pkg/db"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Check implementation
+var _ iface.Database = &MemoryDB{}
+
+func init() {
+	// Backend has no options, its configuration block is ignored
+	db.Register(`memory`, func(cfg json.RawMessage) (iface.Database, error) {
+		return New(), nil
+	})
+}
+
+type key struct {
+	name   string
+	t      string // Rule type
+	action string
+}
+
+type entry struct {
+	opts     iface.RuleOptions
+	hits     uint64
+	lastSeen time.Time
+	created  time.Time
+}
+
+type MemoryDB struct {
+	mu    sync.RWMutex
+	rules map[key]*entry
+}
+
+func New() *MemoryDB {
+	return &MemoryDB{
+		rules: make(map[key]*entry),
+	}
+}
+
+// match finds the most specific rule matching name.
+// Exact rules are checked first and then subtree rules of parent domains. Deny wins over allow on the same name.
+func (m *MemoryDB) match(name string, t string) (k key, ok bool) {
+	now := time.Now()
+	t = iface.RuleType(t)
+	labels := strings.Split(name, `.`)
+
+	for i := range labels {
+		candidate := strings.Join(labels[i:], `.`)
+
+		for _, action := range []string{iface.ActionDeny, iface.ActionAllow} {
+			k = key{name: candidate, t: t, action: action}
+
+			e, found := m.rules[k]
+			if !found {
+				continue
+			}
+
+			if !e.opts.Expires.IsZero() && now.After(e.opts.Expires) {
+				continue
+			}
+
+			if i == 0 || e.opts.Subtree {
+				return k, true
+			}
+		}
+	}
+
+	return key{}, false
+}
+
+func (m *MemoryDB) allowed(name string, t string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	k, ok := m.match(name, t)
+	return ok && k.action == iface.ActionAllow, nil
+}
+
+func (m *MemoryDB) AllowedA(name string) (bool, error) {
+	return m.allowed(name, `A`)
+}
+
+func (m *MemoryDB) AllowedAAAA(name string) (bool, error) {
+	return m.allowed(name, `AAAA`)
+}
+
+func (m *MemoryDB) AllowedPTR(name string) (bool, error) {
+	return m.allowed(name, `PTR`)
+}
+
+func (m *MemoryDB) AllowedType(name string, t string) (bool, error) {
+	return m.allowed(name, t)
+}
+
+// write creates or replaces rule, hit counters of a replaced rule are kept
+func (m *MemoryDB) write(name string, t string, action string, opts iface.RuleOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := key{name: name, t: iface.RuleType(t), action: action}
+
+	e, ok := m.rules[k]
+	if !ok {
+		e = &entry{}
+		m.rules[k] = e
+	}
+
+	e.opts = opts
+	e.created = time.Now()
+
+	return nil
+}
+
+func (m *MemoryDB) AllowA(name string) error {
+	return m.write(name, `A`, iface.ActionAllow, iface.RuleOptions{})
+}
+
+func (m *MemoryDB) AllowAAAA(name string) error {
+	return m.write(name, `AAAA`, iface.ActionAllow, iface.RuleOptions{})
+}
+
+func (m *MemoryDB) AllowPTR(name string) error {
+	return m.write(name, `PTR`, iface.ActionAllow, iface.RuleOptions{})
+}
+
+func (m *MemoryDB) Allow(name string, t string, opts iface.RuleOptions) error {
+	return m.write(name, t, iface.ActionAllow, opts)
+}
+
+func (m *MemoryDB) Deny(name string, t string, opts iface.RuleOptions) error {
+	return m.write(name, t, iface.ActionDeny, opts)
+}
+
+func (m *MemoryDB) Revoke(name string, t string, action string) error {
+	switch action {
+	case iface.ActionAllow, iface.ActionDeny:
+	default:
+		return fmt.Errorf(`unknown action %q`, action)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := key{name: name, t: iface.RuleType(t), action: action}
+
+	if _, ok := m.rules[k]; !ok {
+		return fmt.Errorf(`rule %s %s %s: %w`, action, t, name, os.ErrNotExist)
+	}
+
+	delete(m.rules, k)
+
+	return nil
+}
+
+func (m *MemoryDB) rule(k key, e *entry) iface.Rule {
+	r := iface.Rule{
+		RuleOptions: e.opts,
+		Name:        k.name,
+		Type:        k.t,
+		Action:      k.action,
+		Created:     e.created,
+	}
+
+	if k.action == iface.ActionAllow {
+		r.Hits = e.hits
+		r.LastSeen = e.lastSeen
+	}
+
+	return r
+}
+
+// Match gets the rule deciding query of name, ok is false if no rule matches
+func (m *MemoryDB) Match(name string, t string) (r iface.Rule, ok bool, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	k, ok := m.match(name, t)
+	if !ok {
+		return r, false, nil
+	}
+
+	return m.rule(k, m.rules[k]), true, nil
+}
+
+// Rule gets a single rule, ok is false if it doesn't exist
+func (m *MemoryDB) Rule(name string, t string, action string) (r iface.Rule, ok bool, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	k := key{name: name, t: iface.RuleType(t), action: action}
+
+	e, ok := m.rules[k]
+	if !ok {
+		return r, false, nil
+	}
+
+	return m.rule(k, e), true, nil
+}
+
+// Rules lists all rules with hit counters of allow rules
+func (m *MemoryDB) Rules() (rules []iface.Rule, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for k, e := range m.rules {
+		rules = append(rules, m.rule(k, e))
+	}
+
+	return rules, nil
+}
+
+// RecordHits adds batched hit counters to the allow rules that matched
+func (m *MemoryDB) RecordHits(batch []iface.Hit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, h := range batch {
+		k, ok := m.match(h.Name, h.Type)
+		if !ok || k.action != iface.ActionAllow {
+			continue
+		}
+
+		e := m.rules[k]
+		e.hits += h.Count
+
+		if h.LastSeen.After(e.lastSeen) {
+			e.lastSeen = h.LastSeen
+		}
+	}
+
+	return nil
+}
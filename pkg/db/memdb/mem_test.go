@@ -0,0 +1,14 @@
+package memdb
+
+import (
+	"github.com/raspi/torjuja/pkg/db/dbtest"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) iface.Database {
+		// Memory backend has no storage, TempDir is not needed
+		return New()
+	})
+}
@@ -0,0 +1,320 @@
+package dnstap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// decode decodes protobuf b to values of each field number:
+// uint64 for varints, []byte for bytes and uint32 for fixed32
+func decode(b []byte) (map[uint64][]interface{}, error) {
+	fields := make(map[uint64][]interface{})
+
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf(`invalid tag`)
+		}
+
+		b = b[n:]
+		field := key >> 3
+
+		switch key & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, fmt.Errorf(`field %d: invalid varint`, field)
+			}
+
+			b = b[n:]
+			fields[field] = append(fields[field], v)
+
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, fmt.Errorf(`field %d: invalid length`, field)
+			}
+
+			fields[field] = append(fields[field], b[n:n+int(l)])
+			b = b[n+int(l):]
+
+		case wireFixed32:
+			if len(b) < 4 {
+				return nil, fmt.Errorf(`field %d: short fixed32`, field)
+			}
+
+			fields[field] = append(fields[field], binary.LittleEndian.Uint32(b))
+			b = b[4:]
+
+		default:
+			return nil, fmt.Errorf(`field %d: unknown wire type %d`, field, key&7)
+		}
+	}
+
+	return fields, nil
+}
+
+func TestEncoder(t *testing.T) {
+	tests := []struct {
+		name string
+		e    encoder
+		want []byte
+	}{
+		{`small varint`, encoder{}.uint(1, 5), []byte{0x08, 0x05}},
+		{`multi-byte varint`, encoder{}.uint(1, 300), []byte{0x08, 0xac, 0x02}},
+		{`large field number`, encoder{}.uint(16, 1), []byte{0x80, 0x01, 0x01}},
+		{`bytes`, encoder{}.bytes(2, []byte(`abc`)), []byte{0x12, 0x03, 'a', 'b', 'c'}},
+		{`empty bytes`, encoder{}.bytes(2, []byte{}), []byte{0x12, 0x00}},
+		{`fixed32`, encoder{}.fixed32(9, 0x01020304), []byte{0x4d, 0x04, 0x03, 0x02, 0x01}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !bytes.Equal(tt.e, tt.want) {
+				t.Fatalf(`got % x, want % x`, []byte(tt.e), tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	qt := time.Unix(1600000000, 123456789)
+	rt := qt.Add(time.Millisecond)
+
+	tests := []struct {
+		name string
+		m    Message
+		want map[uint64]interface{} // Expected single value of each field
+	}{
+		{
+			`client query over UDP`,
+			Message{
+				Type:         ClientQuery,
+				Protocol:     SocketProtocolUDP,
+				QueryAddress: net.ParseIP(`192.0.2.1`),
+				QueryPort:    53000,
+				QueryTime:    qt,
+				QueryMessage: []byte{1, 2, 3},
+			},
+			map[uint64]interface{}{
+				1:  uint64(ClientQuery),
+				2:  uint64(socketFamilyINET),
+				3:  uint64(SocketProtocolUDP),
+				4:  []byte{192, 0, 2, 1},
+				6:  uint64(53000),
+				8:  uint64(1600000000),
+				9:  uint32(123456789),
+				10: []byte{1, 2, 3},
+			},
+		},
+		{
+			`forwarder response over IPv6`,
+			Message{
+				Type:            ForwarderResponse,
+				Protocol:        SocketProtocolTCP,
+				ResponseAddress: net.ParseIP(`2001:db8::53`),
+				ResponsePort:    53,
+				QueryTime:       qt,
+				ResponseTime:    rt,
+				ResponseMessage: []byte{4, 5},
+			},
+			map[uint64]interface{}{
+				1:  uint64(ForwarderResponse),
+				2:  uint64(socketFamilyINET6),
+				3:  uint64(SocketProtocolTCP),
+				5:  []byte(net.ParseIP(`2001:db8::53`)),
+				7:  uint64(53),
+				8:  uint64(1600000000),
+				9:  uint32(123456789),
+				12: uint64(1600000000),
+				13: uint32(124456789),
+				14: []byte{4, 5},
+			},
+		},
+		{
+			`only type`,
+			Message{Type: ForwarderQuery},
+			map[uint64]interface{}{
+				1: uint64(ForwarderQuery),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := decode(tt.m.marshal())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(fields) != len(tt.want) {
+				t.Fatalf(`got fields %v, want %v`, fields, tt.want)
+			}
+
+			for f, want := range tt.want {
+				got := fields[f]
+				if len(got) != 1 {
+					t.Fatalf(`field %d: got %d values`, f, len(got))
+				}
+
+				if fmt.Sprintf(`%v`, got[0]) != fmt.Sprintf(`%v`, want) {
+					t.Fatalf(`field %d: got %v, want %v`, f, got[0], want)
+				}
+			}
+		})
+	}
+}
+
+func TestMarshalDnstap(t *testing.T) {
+	m := Message{Type: ClientResponse}
+
+	fields, err := decode(marshalDnstap([]byte(`ns1`), []byte(`torjuja`), m))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(fields[1][0].([]byte)) != `ns1` || string(fields[2][0].([]byte)) != `torjuja` {
+		t.Fatalf(`got identity %q and version %q`, fields[1][0], fields[2][0])
+	}
+
+	if fields[15][0].(uint64) != dnstapTypeMessage {
+		t.Fatalf(`got type %v`, fields[15][0])
+	}
+
+	if !bytes.Equal(fields[14][0].([]byte), m.marshal()) {
+		t.Fatal(`message not embedded`)
+	}
+
+	fields, err = decode(marshalDnstap(nil, nil, m))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := fields[1]; ok {
+		t.Fatal(`empty identity encoded`)
+	}
+}
+
+// readFrame reads a data frame or, for control frames, returns nil and the control frame type
+func readFrame(r io.Reader) (data []byte, control uint32, err error) {
+	var l [4]byte
+
+	_, err = io.ReadFull(r, l[:])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	n := binary.BigEndian.Uint32(l[:])
+	isControl := n == 0
+
+	if isControl {
+		_, err = io.ReadFull(r, l[:])
+		if err != nil {
+			return nil, 0, err
+		}
+
+		n = binary.BigEndian.Uint32(l[:])
+	}
+
+	b := make([]byte, n)
+
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if isControl {
+		return nil, binary.BigEndian.Uint32(b[:4]), nil
+	}
+
+	return b, 0, nil
+}
+
+func TestOutput(t *testing.T) {
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	errch := make(chan error, 10)
+
+	o, err := New(`tcp`, l.Addr().String(), `ns1`, ``, 10, errch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(conn)
+
+	_, control, err := readFrame(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if control != controlReady {
+		t.Fatalf(`got control frame %d, want READY`, control)
+	}
+
+	w := bufio.NewWriter(conn)
+
+	err = writeControl(w, controlAccept, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, control, err = readFrame(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if control != controlStart {
+		t.Fatalf(`got control frame %d, want START`, control)
+	}
+
+	if !o.Send(Message{Type: ClientQuery}) {
+		t.Fatal(`message dropped`)
+	}
+
+	data, _, err := readFrame(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields, err := decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(fields[1][0].([]byte)) != `ns1` {
+		t.Fatalf(`got identity %q`, fields[1][0])
+	}
+
+	select {
+	case err = <-errch:
+		t.Fatal(err)
+	default:
+	}
+}
+
+func TestNewUnknownNetwork(t *testing.T) {
+	if _, err := New(`udp`, `127.0.0.1:6000`, ``, ``, 1, make(chan error, 1)); err == nil {
+		t.Fatal(`udp accepted`)
+	}
+}
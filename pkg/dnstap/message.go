@@ -0,0 +1,165 @@
+package dnstap
+
+/*
+Protobuf encoding of dnstap messages, see https://dnstap.info/ and dnstap.proto
+*/
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// MessageType is dnstap Message.Type
+type MessageType uint64
+
+const (
+	ClientQuery       MessageType = 5
+	ClientResponse    MessageType = 6
+	ForwarderQuery    MessageType = 7
+	ForwarderResponse MessageType = 8
+)
+
+const (
+	socketFamilyINET  = 1
+	socketFamilyINET6 = 2
+
+	SocketProtocolUDP = 1
+	SocketProtocolTCP = 2
+
+	dnstapTypeMessage = 1
+)
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Message is a single dnstap Message
+type Message struct {
+	Type            MessageType
+	Protocol        uint64 // SocketProtocolUDP or SocketProtocolTCP
+	QueryAddress    net.IP
+	QueryPort       uint32
+	ResponseAddress net.IP
+	ResponsePort    uint32
+	QueryTime       time.Time
+	QueryMessage    []byte // Packed DNS message
+	ResponseTime    time.Time
+	ResponseMessage []byte // Packed DNS message
+}
+
+type encoder []byte
+
+func (e encoder) varint(v uint64) encoder {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(e, buf[:n]...)
+}
+
+func (e encoder) tag(field uint64, wire uint64) encoder {
+	return e.varint(field<<3 | wire)
+}
+
+func (e encoder) uint(field uint64, v uint64) encoder {
+	return e.tag(field, wireVarint).varint(v)
+}
+
+func (e encoder) bytes(field uint64, b []byte) encoder {
+	return append(e.tag(field, wireBytes).varint(uint64(len(b))), b...)
+}
+
+func (e encoder) fixed32(field uint64, v uint32) encoder {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(e.tag(field, wireFixed32), buf[:]...)
+}
+
+// marshal encodes Message protobuf
+func (m Message) marshal() []byte {
+	var e encoder
+
+	e = e.uint(1, uint64(m.Type))
+
+	addr := m.QueryAddress
+	if addr == nil {
+		addr = m.ResponseAddress
+	}
+
+	if addr != nil {
+		if addr.To4() != nil {
+			e = e.uint(2, socketFamilyINET)
+		} else {
+			e = e.uint(2, socketFamilyINET6)
+		}
+	}
+
+	if m.Protocol != 0 {
+		e = e.uint(3, m.Protocol)
+	}
+
+	if m.QueryAddress != nil {
+		e = e.bytes(4, ipBytes(m.QueryAddress))
+	}
+
+	if m.ResponseAddress != nil {
+		e = e.bytes(5, ipBytes(m.ResponseAddress))
+	}
+
+	if m.QueryPort != 0 {
+		e = e.uint(6, uint64(m.QueryPort))
+	}
+
+	if m.ResponsePort != 0 {
+		e = e.uint(7, uint64(m.ResponsePort))
+	}
+
+	if !m.QueryTime.IsZero() {
+		e = e.uint(8, uint64(m.QueryTime.Unix()))
+		e = e.fixed32(9, uint32(m.QueryTime.Nanosecond()))
+	}
+
+	if m.QueryMessage != nil {
+		e = e.bytes(10, m.QueryMessage)
+	}
+
+	if !m.ResponseTime.IsZero() {
+		e = e.uint(12, uint64(m.ResponseTime.Unix()))
+		e = e.fixed32(13, uint32(m.ResponseTime.Nanosecond()))
+	}
+
+	if m.ResponseMessage != nil {
+		e = e.bytes(14, m.ResponseMessage)
+	}
+
+	return e
+}
+
+// ipBytes returns 4 bytes for IPv4 and 16 bytes for IPv6 addresses
+func ipBytes(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+
+	return ip.To16()
+}
+
+// marshalDnstap wraps message in the top level Dnstap protobuf
+func marshalDnstap(identity []byte, version []byte, m Message) []byte {
+	var e encoder
+
+	if identity != nil {
+		e = e.bytes(1, identity)
+	}
+
+	if version != nil {
+		e = e.bytes(2, version)
+	}
+
+	e = e.bytes(14, m.marshal())
+	e = e.uint(15, dnstapTypeMessage)
+
+	return e
+}
@@ -0,0 +1,249 @@
+package dnstap
+
+/*
+Frame Streams (https://github.com/farsightsec/fstrm) bidirectional writer
+*/
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const contentType = `protobuf:dnstap.Dnstap`
+
+// Frame Streams control frame types
+const (
+	controlAccept = 0x01
+	controlStart  = 0x02
+	controlReady  = 0x04
+
+	controlFieldContentType = 0x01
+)
+
+const (
+	reconnectInterval = 5 * time.Second
+	flushInterval     = time.Second
+	timeout           = 5 * time.Second
+)
+
+// Output sends dnstap messages to a collector over unix socket or TCP.
+// Messages are buffered and dropped when the buffer is full so that a slow collector never blocks the caller.
+type Output struct {
+	network  string // unix or tcp
+	address  string
+	identity []byte
+	version  []byte
+	ch       chan []byte
+	errch    chan error
+}
+
+// New starts writer connecting to address. bufSize is the number of messages buffered.
+func New(network string, address string, identity string, version string, bufSize int, errch chan error) (*Output, error) {
+	switch network {
+	case `unix`, `tcp`:
+	default:
+		return nil, fmt.Errorf(`dnstap: unknown network %q`, network)
+	}
+
+	o := &Output{
+		network: network,
+		address: address,
+		ch:      make(chan []byte, bufSize),
+		errch:   errch,
+	}
+
+	if identity != `` {
+		o.identity = []byte(identity)
+	}
+
+	if version != `` {
+		o.version = []byte(version)
+	}
+
+	go o.run()
+
+	return o, nil
+}
+
+// Send queues message. Returns false if the message was dropped.
+func (o *Output) Send(m Message) bool {
+	select {
+	case o.ch <- marshalDnstap(o.identity, o.version, m):
+		return true
+	default:
+		return false
+	}
+}
+
+// run keeps connection to the collector open and writes queued messages
+func (o *Output) run() {
+	failing := false
+
+	for {
+		err := o.connect()
+
+		// Report only the first error of consecutive failures
+		if err != nil && !failing {
+			o.errch <- fmt.Errorf(`dnstap: %w`, err)
+		}
+
+		failing = err != nil
+
+		// Drop messages while disconnected
+		deadline := time.After(reconnectInterval)
+
+	drain:
+		for {
+			select {
+			case <-o.ch:
+			case <-deadline:
+				break drain
+			}
+		}
+	}
+}
+
+func (o *Output) connect() error {
+	conn, err := net.DialTimeout(o.network, o.address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return err
+	}
+
+	// Handshake
+	err = writeControl(w, controlReady, true)
+	if err != nil {
+		return err
+	}
+
+	t, err := readControl(r)
+	if err != nil {
+		return err
+	}
+
+	if t != controlAccept {
+		return fmt.Errorf(`expected ACCEPT, got control frame %d`, t)
+	}
+
+	err = writeControl(w, controlStart, true)
+	if err != nil {
+		return err
+	}
+
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case b := <-o.ch:
+			err = conn.SetWriteDeadline(time.Now().Add(timeout))
+			if err != nil {
+				return err
+			}
+
+			err = writeFrame(w, b)
+			if err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if w.Buffered() == 0 {
+				continue
+			}
+
+			err = conn.SetWriteDeadline(time.Now().Add(timeout))
+			if err != nil {
+				return err
+			}
+
+			err = w.Flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeFrame(w *bufio.Writer, b []byte) error {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(b)))
+
+	_, err := w.Write(l[:])
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+// writeControl writes control frame t, optionally with content type field
+func writeControl(w *bufio.Writer, t uint32, withContentType bool) error {
+	frame := make([]byte, 4)
+	binary.BigEndian.PutUint32(frame, t)
+
+	if withContentType {
+		var hdr [8]byte
+		binary.BigEndian.PutUint32(hdr[:4], controlFieldContentType)
+		binary.BigEndian.PutUint32(hdr[4:], uint32(len(contentType)))
+		frame = append(frame, hdr[:]...)
+		frame = append(frame, contentType...)
+	}
+
+	// Escape sequence: zero length data frame
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	if err != nil {
+		return err
+	}
+
+	err = writeFrame(w, frame)
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// readControl reads control frame and returns its type
+func readControl(r *bufio.Reader) (uint32, error) {
+	var hdr [8]byte
+
+	_, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return 0, err
+	}
+
+	if binary.BigEndian.Uint32(hdr[:4]) != 0 {
+		return 0, fmt.Errorf(`expected control frame`)
+	}
+
+	l := binary.BigEndian.Uint32(hdr[4:])
+	if l < 4 || l > 512 {
+		return 0, fmt.Errorf(`invalid control frame length %d`, l)
+	}
+
+	frame := make([]byte, l)
+
+	_, err = io.ReadFull(r, frame)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint32(frame[:4]), nil
+}
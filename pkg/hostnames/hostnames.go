@@ -0,0 +1,314 @@
+package hostnames
+
+/*
+Host names and addresses from hosts files and DHCP server lease files
+*/
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File formats
+const (
+	FormatHosts   = `hosts`   // /etc/hosts
+	FormatDnsmasq = `dnsmasq` // dnsmasq.leases
+	FormatDhcpd   = `dhcpd`   // ISC dhcpd.leases
+	FormatKea     = `kea`     // Kea memfile CSV
+)
+
+// Host is a name of an address
+type Host struct {
+	Name string // As written in the file, can be a single label
+	IP   net.IP
+}
+
+// ValidFormat checks that f is a known format
+func ValidFormat(f string) bool {
+	switch f {
+	case FormatHosts, FormatDnsmasq, FormatDhcpd, FormatKea:
+		return true
+	}
+
+	return false
+}
+
+// ReadFile reads hosts of file p in format f. Expired leases are skipped.
+func ReadFile(p string, f string) ([]Host, error) {
+	fh, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	hosts, err := Parse(fh, f, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf(`%s: %w`, p, err)
+	}
+
+	return hosts, nil
+}
+
+// Parse reads hosts from r in format f. Leases expired at now are skipped.
+func Parse(r io.Reader, f string, now time.Time) ([]Host, error) {
+	switch f {
+	case FormatHosts:
+		return parseHosts(r)
+	case FormatDnsmasq:
+		return parseDnsmasq(r, now)
+	case FormatDhcpd:
+		return parseDhcpd(r, now)
+	case FormatKea:
+		return parseKea(r, now)
+	default:
+		return nil, fmt.Errorf(`unknown format %q`, f)
+	}
+}
+
+// parseHosts parses lines "address name [aliases...]"
+func parseHosts(r io.Reader) (hosts []Host, err error) {
+	sc := bufio.NewScanner(r)
+
+	for sc.Scan() {
+		line := sc.Text()
+
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		ip := net.ParseIP(fields[0])
+		if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() {
+			continue
+		}
+
+		for _, name := range fields[1:] {
+			hosts = append(hosts, Host{Name: name, IP: ip})
+		}
+	}
+
+	return hosts, sc.Err()
+}
+
+// parseDnsmasq parses lines "expiry mac address hostname clientid"
+func parseDnsmasq(r io.Reader, now time.Time) (hosts []Host, err error) {
+	sc := bufio.NewScanner(r)
+
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 4 {
+			// DHCPv6 "duid" line and empty lines
+			continue
+		}
+
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid expiry %q`, fields[0])
+		}
+
+		// 0 is infinite lease
+		if expiry != 0 && time.Unix(expiry, 0).Before(now) {
+			continue
+		}
+
+		ip := net.ParseIP(fields[2])
+		if ip == nil || fields[3] == `*` {
+			continue
+		}
+
+		hosts = append(hosts, Host{Name: fields[3], IP: ip})
+	}
+
+	return hosts, sc.Err()
+}
+
+// parseDhcpd parses ISC dhcpd lease blocks. Later blocks of the same address replace earlier ones.
+func parseDhcpd(r io.Reader, now time.Time) (hosts []Host, err error) {
+	type lease struct {
+		ip     net.IP
+		name   string
+		ends   time.Time
+		active bool
+	}
+
+	var leases []*lease
+	byIP := make(map[string]int)
+	var cur *lease
+
+	sc := bufio.NewScanner(r)
+
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		line = strings.TrimSuffix(line, `;`)
+		fields := strings.Fields(line)
+
+		if len(fields) == 0 || strings.HasPrefix(fields[0], `#`) {
+			continue
+		}
+
+		switch {
+		case fields[0] == `lease` && len(fields) >= 2:
+			cur = &lease{ip: net.ParseIP(fields[1]), active: true}
+		case fields[0] == `}`:
+			if cur != nil && cur.ip != nil {
+				k := cur.ip.String()
+
+				if i, ok := byIP[k]; ok {
+					leases[i] = cur
+				} else {
+					byIP[k] = len(leases)
+					leases = append(leases, cur)
+				}
+			}
+
+			cur = nil
+		case cur == nil:
+		case fields[0] == `client-hostname` && len(fields) >= 2:
+			cur.name = strings.Trim(fields[1], `"`)
+		case fields[0] == `ends` && len(fields) >= 4:
+			// ends 4 2021/04/29 12:00:00
+			cur.ends, err = time.Parse(`2006/01/02 15:04:05`, fields[2]+` `+fields[3])
+			if err != nil {
+				return nil, fmt.Errorf(`lease %s: %w`, cur.ip, err)
+			}
+		case fields[0] == `binding` && len(fields) >= 3:
+			cur.active = fields[2] == `active`
+		}
+	}
+
+	if err = sc.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, l := range leases {
+		if l.name == `` || !l.active || (!l.ends.IsZero() && l.ends.Before(now)) {
+			continue
+		}
+
+		hosts = append(hosts, Host{Name: l.name, IP: l.ip})
+	}
+
+	return hosts, nil
+}
+
+// parseKea parses Kea memfile CSV with header line
+func parseKea(r io.Reader, now time.Time) (hosts []Host, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	col := make(map[string]int)
+	for i, name := range header {
+		col[name] = i
+	}
+
+	for _, name := range []string{`address`, `expire`, `hostname`, `state`} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf(`missing column %q`, name)
+		}
+	}
+
+	// Later lines of the same address replace earlier ones
+	byIP := make(map[string]int)
+
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rec) < len(header) {
+			continue
+		}
+
+		ip := net.ParseIP(rec[col[`address`]])
+		if ip == nil {
+			continue
+		}
+
+		k := ip.String()
+
+		expire, err := strconv.ParseInt(rec[col[`expire`]], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf(`lease %s: invalid expire %q`, k, rec[col[`expire`]])
+		}
+
+		// State 0 is a valid lease
+		valid := rec[col[`state`]] == `0` && time.Unix(expire, 0).After(now) && rec[col[`hostname`]] != ``
+
+		h := Host{Name: strings.TrimSuffix(rec[col[`hostname`]], `.`), IP: ip}
+
+		i, seen := byIP[k]
+
+		switch {
+		case seen && valid:
+			hosts[i] = h
+		case seen:
+			hosts[i].Name = ``
+		case valid:
+			byIP[k] = len(hosts)
+			hosts = append(hosts, h)
+		}
+	}
+
+	l := hosts[:0]
+	for _, h := range hosts {
+		if h.Name != `` {
+			l = append(l, h)
+		}
+	}
+
+	return l, nil
+}
+
+// Watch calls fn with hosts of file p whenever its modification time changes.
+// Only the first error of consecutive failures is sent to errch.
+func Watch(p string, f string, interval time.Duration, fn func([]Host), errch chan error) {
+	var modified time.Time
+	failing := false
+
+	for {
+		fi, err := os.Stat(p)
+
+		if err == nil && !fi.ModTime().Equal(modified) {
+			var hosts []Host
+
+			hosts, err = ReadFile(p, f)
+			if err == nil {
+				modified = fi.ModTime()
+				fn(hosts)
+			}
+		}
+
+		if err != nil && !failing {
+			errch <- fmt.Errorf(`hosts: %w`, err)
+		}
+
+		failing = err != nil
+
+		time.Sleep(interval)
+	}
+}
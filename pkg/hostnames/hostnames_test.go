@@ -0,0 +1,146 @@
+package hostnames
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2021, 4, 29, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		want   []string // "name address"
+	}{
+		{
+			`hosts`,
+			FormatHosts,
+			`# comment
+127.0.0.1 localhost
+::1 localhost ip6-localhost
+0.0.0.0 blocked.example
+ff02::1 ip6-allnodes
+192.168.1.2   nas nas.lan   # trailing comment
+192.168.1.3	printer
+not-an-address host
+192.168.1.4
+fd00::5 router.lan
+`,
+			[]string{`nas 192.168.1.2`, `nas.lan 192.168.1.2`, `printer 192.168.1.3`, `router.lan fd00::5`},
+		},
+		{
+			`dnsmasq`,
+			FormatDnsmasq,
+			`1619697600 aa:bb:cc:dd:ee:01 192.168.1.10 laptop 01:aa:bb:cc:dd:ee:01
+1619690000 aa:bb:cc:dd:ee:02 192.168.1.11 expired *
+0 aa:bb:cc:dd:ee:03 192.168.1.12 infinite *
+0 aa:bb:cc:dd:ee:04 192.168.1.13 * *
+duid 00:01:00:01:aa:bb:cc:dd:ee:ff:00:11
+1619697600 1234 fd00::10 phone 00:01:00:01
+`,
+			[]string{`laptop 192.168.1.10`, `infinite 192.168.1.12`, `phone fd00::10`},
+		},
+		{
+			`dhcpd`,
+			FormatDhcpd,
+			`# The format of this file is documented in the dhcpd.leases(5) manual page.
+lease 192.168.1.20 {
+  starts 4 2021/04/29 10:00:00;
+  ends 4 2021/04/29 14:00:00;
+  binding state active;
+  client-hostname "desktop";
+}
+lease 192.168.1.21 {
+  ends 4 2021/04/29 11:00:00;
+  binding state active;
+  client-hostname "expired";
+}
+lease 192.168.1.22 {
+  ends 4 2021/04/29 14:00:00;
+  binding state free;
+  client-hostname "released";
+}
+lease 192.168.1.23 {
+  ends never;
+  binding state active;
+}
+lease 192.168.1.24 {
+  ends never;
+  binding state active;
+  client-hostname "old-name";
+}
+lease 192.168.1.24 {
+  ends never;
+  binding state active;
+  client-hostname "tv";
+}
+`,
+			[]string{`desktop 192.168.1.20`, `tv 192.168.1.24`},
+		},
+		{
+			`kea`,
+			FormatKea,
+			`address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context
+192.168.1.30,aa:bb:cc:dd:ee:30,,3600,1619701200,1,0,0,tablet.lan.,0,
+192.168.1.31,aa:bb:cc:dd:ee:31,,3600,1619690000,1,0,0,expired,0,
+192.168.1.32,aa:bb:cc:dd:ee:32,,3600,1619701200,1,0,0,declined,1,
+192.168.1.33,aa:bb:cc:dd:ee:33,,3600,1619701200,1,0,0,,0,
+192.168.1.34,aa:bb:cc:dd:ee:34,,3600,1619701200,1,0,0,watch,0,
+192.168.1.34,aa:bb:cc:dd:ee:34,,0,1619690000,1,0,0,watch,0,
+192.168.1.35,aa:bb:cc:dd:ee:35,,3600,1619701200,1,0,0,first,0,
+192.168.1.35,aa:bb:cc:dd:ee:35,,3600,1619701200,1,0,0,renamed,0,
+`,
+			[]string{`tablet.lan 192.168.1.30`, `renamed 192.168.1.35`},
+		},
+		{
+			`empty kea`,
+			FormatKea,
+			``,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts, err := Parse(strings.NewReader(tt.input), tt.format, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, h := range hosts {
+				got = append(got, h.Name+` `+h.IP.String())
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf(`got %q, want %q`, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{`unknown format`, `bogus`, ``},
+		{`dnsmasq expiry`, FormatDnsmasq, "soon aa:bb:cc:dd:ee:01 192.168.1.10 laptop *\n"},
+		{`dhcpd ends`, FormatDhcpd, "lease 192.168.1.20 {\n  ends 4 2021-04-29 14:00:00;\n}\n"},
+		{`kea missing column`, FormatKea, "address,expire,state\n192.168.1.30,1619701200,0\n"},
+		{`kea expire`, FormatKea, "address,expire,hostname,state\n192.168.1.30,soon,tablet,0\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tt.input), tt.format, time.Now()); err == nil {
+				t.Fatal(`no error`)
+			}
+		})
+	}
+}
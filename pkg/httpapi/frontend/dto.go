@@ -1,9 +1,96 @@
 package frontend
 
+import "github.com/raspi/torjuja/pkg/querylog"
+
 type AllowDTO struct {
-	FQDN string `json:"fqdn"`
+	FQDN    string   `json:"fqdn"`    // Internationalized names are converted to punycode, IP address or reverse lookup name for PTR
+	Types   []string `json:"types"`   // Record types, A and AAAA if empty
+	Scope   string   `json:"scope"`   // exact (default) or subtree
+	Expires string   `json:"expires"` // RFC 3339, empty for permanent
+	Comment string   `json:"comment"`
 }
 
 type ResponseDTO struct {
 	Message string `json:"msg"`
 }
+
+// FieldErrorDTO is a validation error of a single request field
+type FieldErrorDTO struct {
+	Field   string `json:"field"` // JSON name of the field, empty if not related to a single field
+	Message string `json:"msg"`
+}
+
+// ErrorsDTO is returned with 400 Bad Request when request doesn't validate
+type ErrorsDTO struct {
+	Errors []FieldErrorDTO `json:"errors"`
+}
+
+type RuleDTO struct {
+	FQDN      string `json:"fqdn"`
+	Type      string `json:"type"`
+	Action    string `json:"action"`  // allow or deny
+	Scope     string `json:"scope"`   // exact or subtree
+	Expires   string `json:"expires"` // RFC 3339, empty if permanent
+	Comment   string `json:"comment"`
+	BlockMode string `json:"block_mode"` // Deny rules only, empty for the default of the client
+	Hits      uint64 `json:"hits"`
+	LastSeen  string `json:"last_seen"` // RFC 3339, empty if never matched
+}
+
+// LocalRecordDTO is a locally answered record
+type LocalRecordDTO struct {
+	Record string `json:"record"` // Zone file format, for example "nas.lan. 300 IN A 192.168.1.2"
+	File   string `json:"file"`   // Zone file the record is defined in, empty if managed through the API
+}
+
+// RuleKeyDTO identifies a single rule
+type RuleKeyDTO struct {
+	FQDN   string `json:"fqdn"`
+	Type   string `json:"type"`
+	Action string `json:"action"`
+}
+
+type RevokeDTO struct {
+	Rules []RuleKeyDTO `json:"rules"`
+}
+
+type CountDTO struct {
+	Name  string `json:"name"`
+	Label string `json:"label"` // Host name of client, empty if unknown
+	Count uint64 `json:"count"`
+}
+
+type StatsDTO struct {
+	Window     string     `json:"window"`
+	Total      uint64     `json:"total"`
+	Allowed    uint64     `json:"allowed"`
+	Blocked    uint64     `json:"blocked"`
+	Dropped    uint64     `json:"dropped"` // Rate limited without reply
+	Slipped    uint64     `json:"slipped"` // Rate limited with truncated reply
+	TopQueried []CountDTO `json:"top_queried"`
+	TopBlocked []CountDTO `json:"top_blocked"`
+	TopClients []CountDTO `json:"top_clients"`
+	TopLimited []CountDTO `json:"top_limited"` // Rate limited clients
+	QueryTypes []CountDTO `json:"qtypes"`
+	LatencyP50 float64    `json:"latency_p50"` // Milliseconds
+	LatencyP90 float64    `json:"latency_p90"`
+	LatencyP99 float64    `json:"latency_p99"`
+}
+
+type QueryLogDTO struct {
+	Entries []querylog.Entry `json:"entries"`
+	Cursor  string           `json:"cursor"` // Pass as cursor parameter to get the next page, empty on last page
+}
+
+// EventDTO is sent over SSE for every answered DNS query
+type EventDTO struct {
+	Time     string `json:"time"` // RFC 3339
+	Client   string `json:"client"`
+	Hostname string `json:"hostname"` // Local name of client, empty if unknown
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Decision string `json:"decision"` // allowed, blocked or error
+	Reason   string `json:"reason"`
+	Rule     string `json:"rule"`
+	IP       string `json:"ip"` // Address in the forwarded answer that blocked the query, empty if blocked by name
+}
@@ -0,0 +1,168 @@
+package frontend
+
+import (
+	"encoding/json"
+	"github.com/alexandrevicenzi/go-sse"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// backlogSize is the number of recent events kept for replaying on page load
+const backlogSize = 1000
+
+// eventBacklog is a ring buffer of recent events
+type eventBacklog struct {
+	mu     sync.Mutex
+	events []EventDTO
+	next   int // Index of next write
+	full   bool
+}
+
+func newEventBacklog(size int) *eventBacklog {
+	return &eventBacklog{
+		events: make([]EventDTO, size),
+	}
+}
+
+func (b *eventBacklog) add(e EventDTO) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.events[b.next] = e
+	b.next = (b.next + 1) % len(b.events)
+
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// list returns stored events oldest first
+func (b *eventBacklog) list() []EventDTO {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.full {
+		return append([]EventDTO{}, b.events[:b.next]...)
+	}
+
+	return append(append([]EventDTO{}, b.events[b.next:]...), b.events[:b.next]...)
+}
+
+// eventChannels maps SSE channel paths to the decision of events sent to them, empty for all events
+var eventChannels = map[string]string{
+	`/events/blocked`: `blocked`,
+	`/events/allowed`: `allowed`,
+	`/events/all`:     ``,
+}
+
+// eventFilters are the query parameters accepted by event channels
+var eventFilters = []string{`client`, `name`}
+
+// eventChannelName includes filters in the SSE channel name so that clients with same filters share a channel
+func eventChannelName(request *http.Request) string {
+	q := request.URL.Query()
+	f := url.Values{}
+
+	for _, k := range eventFilters {
+		if v := q.Get(k); v != `` {
+			f.Set(k, strings.ToLower(v))
+		}
+	}
+
+	if len(f) == 0 {
+		return request.URL.Path
+	}
+
+	return request.URL.Path + `?` + f.Encode()
+}
+
+// matchEventChannel checks if event e should be sent to channel created by eventChannelName
+func matchEventChannel(channel string, e EventDTO) bool {
+	p := channel
+	var rawquery string
+
+	if idx := strings.IndexByte(channel, '?'); idx != -1 {
+		p, rawquery = channel[:idx], channel[idx+1:]
+	}
+
+	decision, ok := eventChannels[p]
+	if !ok {
+		return false
+	}
+
+	if decision != `` && decision != e.Decision {
+		return false
+	}
+
+	f, err := url.ParseQuery(rawquery)
+	if err != nil {
+		return false
+	}
+
+	return matchEventFilters(f, e)
+}
+
+// matchEventFilters checks event against client and name (substring) filters ignoring case
+func matchEventFilters(f url.Values, e EventDTO) bool {
+	if v := f.Get(`client`); v != `` && !strings.EqualFold(v, e.Client) && !strings.EqualFold(v, e.Hostname) {
+		return false
+	}
+
+	if v := f.Get(`name`); v != `` && !strings.Contains(strings.ToLower(e.Name), strings.ToLower(v)) {
+		return false
+	}
+
+	return true
+}
+
+// SendEvent publishes event e as SSE event named after its decision to all matching channels
+func (srv *Server) SendEvent(e EventDTO) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		return
+	}
+
+	srv.backlog.add(e)
+
+	id := strconv.FormatUint(atomic.AddUint64(&srv.eventID, 1), 10)
+
+	for _, ch := range srv.sseServer.Channels() {
+		if matchEventChannel(ch, e) {
+			srv.sseServer.SendMessage(ch, sse.NewMessage(id, string(b), e.Decision))
+		}
+	}
+}
+
+// apiEventBacklog returns recent events oldest first so that the frontend can show events that happened before the page was opened.
+// Query parameters decision, client and name (substring) filter the list.
+func (srv *Server) apiEventBacklog(writer http.ResponseWriter, request *http.Request) {
+	q := request.URL.Query()
+	decision := q.Get(`decision`)
+
+	events := []EventDTO{}
+
+	for _, e := range srv.backlog.list() {
+		if decision != `` && decision != e.Decision {
+			continue
+		}
+
+		if !matchEventFilters(q, e) {
+			continue
+		}
+
+		events = append(events, e)
+	}
+
+	err := srv.getStruct(writer, events)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,80 @@
+package frontend
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestMatchEventFilters(t *testing.T) {
+	e := EventDTO{
+		Client:   `2001:db8::a`,
+		Hostname: `Laptop.lan`,
+		Name:     `www.Example.com`,
+		Decision: `blocked`,
+	}
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{``, true},
+		{`client=2001:db8::a`, true},
+		{`client=2001:DB8::A`, true},
+		{`client=laptop.lan`, true},
+		{`client=LAPTOP.LAN`, true},
+		{`client=laptop`, false},
+		{`client=2001:db8::b`, false},
+		{`name=example`, true},
+		{`name=EXAMPLE.COM`, true},
+		{`name=example.org`, false},
+		{`client=laptop.lan&name=www`, true},
+		{`client=laptop.lan&name=ads`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			f, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := matchEventFilters(f, e); got != tt.want {
+				t.Fatalf(`got %v, want %v`, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchEventChannel(t *testing.T) {
+	e := EventDTO{
+		Client:   `192.0.2.1`,
+		Hostname: `Laptop.lan`,
+		Name:     `ads.example.com`,
+		Decision: `blocked`,
+	}
+
+	tests := []struct {
+		url  string // Request URL the channel is created from
+		want bool
+	}{
+		{`/events/all`, true},
+		{`/events/blocked`, true},
+		{`/events/allowed`, false},
+		{`/events/bogus`, false},
+		{`/events/all?client=Laptop.LAN`, true},
+		{`/events/blocked?client=192.0.2.1&name=ADS`, true},
+		{`/events/blocked?client=192.0.2.2`, false},
+		{`/events/all?other=ignored`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			ch := eventChannelName(httptest.NewRequest(`GET`, tt.url, nil))
+
+			if got := matchEventChannel(ch, e); got != tt.want {
+				t.Fatalf(`channel %s: got %v, want %v`, ch, got, tt.want)
+			}
+		})
+	}
+}
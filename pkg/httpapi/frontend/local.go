@@ -0,0 +1,150 @@
+package frontend
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/raspi/torjuja/pkg/audit"
+	"github.com/raspi/torjuja/pkg/localdata"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiLocalRecords lists local records
+func (srv *Server) apiLocalRecords(writer http.ResponseWriter, request *http.Request) {
+	if srv.local == nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	records := srv.local.Records()
+	l := make([]LocalRecordDTO, 0, len(records))
+
+	for _, r := range records {
+		l = append(l, LocalRecordDTO{
+			Record: r.RR.String(),
+			File:   r.File,
+		})
+	}
+
+	err := srv.getStruct(writer, l)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// readLocalRecord reads LocalRecordDTO from request and parses the record.
+// Validation errors are written to writer and nil is returned.
+func (srv *Server) readLocalRecord(writer http.ResponseWriter, request *http.Request) dns.RR {
+	if srv.local == nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	var data LocalRecordDTO
+
+	err := srv.readStruct(request.Body, &data)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return nil
+	}
+
+	rr, err := localdata.Parse(data.Record)
+	if err != nil {
+		var errs validationErrors
+		errs.add(`record`, `%v`, err)
+		errs.write(srv, writer)
+		return nil
+	}
+
+	return rr
+}
+
+// apiAddLocalRecord adds a local record
+func (srv *Server) apiAddLocalRecord(writer http.ResponseWriter, request *http.Request) {
+	rr := srv.readLocalRecord(writer, request)
+	if rr == nil {
+		return
+	}
+
+	err := srv.local.Add(rr)
+	if err != nil {
+		var errs validationErrors
+		errs.add(`record`, `%v`, err)
+		errs.write(srv, writer)
+		return
+	}
+
+	err = srv.auditLocal(request, audit.ActionLocalAdd, rr)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = srv.getStruct(writer, ResponseDTO{
+		Message: `ok`,
+	})
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// apiRemoveLocalRecord removes a local record managed through the API
+func (srv *Server) apiRemoveLocalRecord(writer http.ResponseWriter, request *http.Request) {
+	rr := srv.readLocalRecord(writer, request)
+	if rr == nil {
+		return
+	}
+
+	ok, err := srv.local.Remove(rr)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = srv.auditLocal(request, audit.ActionLocalRemove, rr)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = srv.getStruct(writer, ResponseDTO{
+		Message: fmt.Sprintf(`removed %s`, rr.Header().Name),
+	})
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// auditLocal records a change of local data to the audit trail
+func (srv *Server) auditLocal(request *http.Request, action string, rr dns.RR) error {
+	if srv.audit == nil {
+		return nil
+	}
+
+	return srv.audit.Append(audit.Entry{
+		Time:   time.Now(),
+		Actor:  actor(request),
+		Source: sourceIP(request),
+		Action: action,
+		FQDN:   strings.TrimSuffix(strings.ToLower(rr.Header().Name), `.`),
+		Types:  []string{dns.TypeToString[rr.Header().Rrtype]},
+		Record: rr.String(),
+	})
+}
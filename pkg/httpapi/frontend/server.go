@@ -6,32 +6,59 @@ import (
 	"github.com/alexandrevicenzi/go-sse"
 	"github.com/go-chi/chi/v5"
 	mw "github.com/go-chi/chi/v5/middleware"
+	"github.com/miekg/dns"
 	"github.com/raspi/torjuja/frontend"
+	"github.com/raspi/torjuja/pkg/audit"
 	"github.com/raspi/torjuja/pkg/db/iface"
+	"github.com/raspi/torjuja/pkg/localdata"
+	"github.com/raspi/torjuja/pkg/querylog"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// StatsProvider aggregates query statistics over a time window
+type StatsProvider interface {
+	Stats(window time.Duration) StatsDTO
+}
+
+// statsWindows are the windows accepted by the stats API
+var statsWindows = map[string]time.Duration{
+	`1h`:  time.Hour,
+	`24h`: 24 * time.Hour,
+	`7d`:  7 * 24 * time.Hour,
+}
+
 type Server struct {
-	db        iface.AllowAPI
+	eventID   uint64 // Last SSE event ID, first field for 64-bit atomic alignment
+	db        iface.Database
+	audit     *audit.Log // nil if audit trail is disabled
+	stats     StatsProvider
+	querylog  *querylog.Log   // nil if query log is disabled
+	local     *localdata.Data // nil if local data is disabled
 	rtr       *chi.Mux
 	sseServer *sse.Server
+	backlog   *eventBacklog
 }
 
-func New(db iface.AllowAPI) (s *Server) {
+func New(db iface.Database, auditlog *audit.Log, stats StatsProvider, metrics http.Handler, qlog *querylog.Log, local *localdata.Data) (s *Server) {
 	s = &Server{
-		db: db,
+		db:       db,
+		audit:    auditlog,
+		stats:    stats,
+		querylog: qlog,
+		local:    local,
+		backlog:  newEventBacklog(backlogSize),
 		sseServer: sse.NewServer(&sse.Options{
-			RetryInterval: 5,
-			Logger:        log.New(os.Stdout, `SSE: `, 0),
-			/*ChannelNameFunc: func(request *http.Request) string {
-				log.Printf(`channel name %v`, request.URL.Path)
-				return request.URL.Path
-			},*/
+			RetryInterval:   5,
+			Logger:          log.New(os.Stdout, `SSE: `, 0),
+			ChannelNameFunc: eventChannelName,
 		}),
 	}
 
@@ -40,6 +67,17 @@ func New(db iface.AllowAPI) (s *Server) {
 	apirouter.Use(mw.AllowContentType(`application/json`))
 
 	apirouter.Post(`/allow`, s.apiAllow)
+	apirouter.Get(`/audit`, s.apiAudit)
+	apirouter.Get(`/rules`, s.apiRules)
+	apirouter.Put(`/rules`, s.apiPutRule)
+	apirouter.Post(`/rules/revoke`, s.apiRevokeRules)
+	apirouter.Get(`/rules/stale`, s.apiStaleRules)
+	apirouter.Get(`/stats`, s.apiStats)
+	apirouter.Get(`/querylog`, s.apiQueryLog)
+	apirouter.Get(`/events/backlog`, s.apiEventBacklog)
+	apirouter.Get(`/local`, s.apiLocalRecords)
+	apirouter.Post(`/local`, s.apiAddLocalRecord)
+	apirouter.Post(`/local/remove`, s.apiRemoveLocalRecord)
 
 	router := chi.NewRouter()
 	router.Use(mw.Recoverer)
@@ -51,11 +89,16 @@ func New(db iface.AllowAPI) (s *Server) {
 
 	router.Mount(`/api/v1`, apirouter)
 
+	// Prometheus
+	router.Handle(`/metrics`, metrics)
+
 	// Javascript and CSS
 	router.Get(`/assets/{}`, s.assets)
 
-	// SSE for blocked events
-	router.Handle(`/events/blocked`, s.sseServer)
+	// SSE for query events
+	for p := range eventChannels {
+		router.Handle(p, s.sseServer)
+	}
 
 	s.rtr = router
 
@@ -165,20 +208,36 @@ func (srv *Server) apiAllow(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	err = srv.db.AllowA(data.FQDN)
+	name, types, opts, errs := validateAllow(data)
+	if len(errs) > 0 {
+		errs.write(srv, writer)
+		return
+	}
+
+	prior, err := srv.priorState(name, types)
 	if err != nil {
 		log.Printf(`error: %v`, err)
 		writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	err = srv.db.AllowAAAA(data.FQDN)
+	// Audit entry is written first so that no change is left without one
+	err = srv.auditLog(request, audit.ActionAllow, name, types, opts, prior)
 	if err != nil {
 		log.Printf(`error: %v`, err)
 		writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	for _, t := range types {
+		err = srv.db.Allow(name, t, opts)
+		if err != nil {
+			log.Printf(`error: audit entry was written but allowing %s %s failed: %v`, t, name, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Success
 	err = srv.getStruct(writer, ResponseDTO{
 		Message: `ok`,
@@ -190,8 +249,480 @@ func (srv *Server) apiAllow(writer http.ResponseWriter, request *http.Request) {
 	}
 }
 
-func (srv *Server) SendMessage(s string, message *sse.Message) {
-	srv.sseServer.SendMessage(s, message)
+// priorState gets allow state of name before it is changed for the audit trail
+func (srv *Server) priorState(name string, types []string) (map[string]bool, error) {
+	prior := make(map[string]bool)
+
+	for _, t := range types {
+		allowed, err := srv.db.AllowedType(name, t)
+		if err != nil {
+			return nil, err
+		}
+
+		prior[t] = allowed
+	}
+
+	return prior, nil
+}
+
+// auditLog records a change to the audit trail
+func (srv *Server) auditLog(request *http.Request, action string, name string, types []string, opts iface.RuleOptions, prior map[string]bool) error {
+	if srv.audit == nil {
+		return nil
+	}
+
+	e := audit.Entry{
+		Time:    time.Now(),
+		Actor:   actor(request),
+		Source:  sourceIP(request),
+		Action:  action,
+		FQDN:    name,
+		Types:   types,
+		Subtree: opts.Subtree,
+		Comment: opts.Comment,
+		Prior:   prior,
+	}
+
+	if !opts.Expires.IsZero() {
+		e.Expires = &opts.Expires
+	}
+
+	return srv.audit.Append(e)
+}
+
+// apiAudit lists audit trail entries.
+// Query parameters actor, source, action, fqdn (substring), since and until (RFC 3339) and limit filter the list.
+func (srv *Server) apiAudit(writer http.ResponseWriter, request *http.Request) {
+	if srv.audit == nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	q := request.URL.Query()
+
+	f := audit.Filter{
+		Actor:  q.Get(`actor`),
+		Source: q.Get(`source`),
+		Action: q.Get(`action`),
+		FQDN:   q.Get(`fqdn`),
+		Limit:  100,
+	}
+
+	var err error
+
+	if v := q.Get(`since`); v != `` {
+		f.Since, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if v := q.Get(`until`); v != `` {
+		f.Until, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if v := q.Get(`limit`); v != `` {
+		f.Limit, err = strconv.Atoi(v)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := srv.audit.Find(f)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+
+	err = srv.getStruct(writer, entries)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// apiRules lists all rules with hit counters
+func (srv *Server) apiRules(writer http.ResponseWriter, request *http.Request) {
+	rules, err := srv.db.Rules()
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = srv.getStruct(writer, rulesToDTO(rules))
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// apiPutRule creates a rule or replaces an existing rule of the same name and type.
+// A name and type has only one rule, so changing the action removes the rule with the other action.
+func (srv *Server) apiPutRule(writer http.ResponseWriter, request *http.Request) {
+	var data RuleDTO
+
+	err := srv.readStruct(request.Body, &data)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	name, types, opts, errs := validateRule(data)
+	if len(errs) > 0 {
+		errs.write(srv, writer)
+		return
+	}
+
+	var other string
+	set := srv.db.Allow
+
+	switch data.Action {
+	case iface.ActionAllow:
+		other = iface.ActionDeny
+	case iface.ActionDeny:
+		other = iface.ActionAllow
+		set = srv.db.Deny
+	}
+
+	prior, err := srv.priorState(name, types)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_, exists, err := srv.db.Rule(name, data.Type, other)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Audit entry is written first so that no change is left without one
+	err = srv.auditLog(request, data.Action, name, types, opts, prior)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if exists {
+		err = srv.db.Revoke(name, data.Type, other)
+		if err != nil {
+			log.Printf(`error: audit entry was written but revoking %s %s %s failed: %v`, other, data.Type, name, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = set(name, data.Type, opts)
+	if err != nil {
+		log.Printf(`error: audit entry was written but setting %s %s %s failed: %v`, data.Action, data.Type, name, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = srv.getStruct(writer, ResponseDTO{
+		Message: `ok`,
+	})
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// apiRevokeRules removes a list of rules. Rules that don't exist are skipped.
+func (srv *Server) apiRevokeRules(writer http.ResponseWriter, request *http.Request) {
+	var data RevokeDTO
+
+	err := srv.readStruct(request.Body, &data)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	keys, errs := validateRuleKeys(data.Rules)
+	if len(errs) > 0 {
+		errs.write(srv, writer)
+		return
+	}
+
+	revoked := 0
+
+	for _, k := range keys {
+		r, exists, err := srv.db.Rule(k.FQDN, k.Type, k.Action)
+		if err != nil {
+			log.Printf(`error: %v`, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if !exists {
+			continue
+		}
+
+		prior, err := srv.priorState(k.FQDN, ruleTypes(r.Type))
+		if err != nil {
+			log.Printf(`error: %v`, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Audit entry is written first so that no change is left without one
+		err = srv.auditLog(request, audit.ActionRevoke, k.FQDN, []string{r.Type}, r.RuleOptions, prior)
+		if err != nil {
+			log.Printf(`error: %v`, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		err = srv.db.Revoke(k.FQDN, k.Type, k.Action)
+		if err != nil {
+			log.Printf(`error: audit entry was written but revoking %s %s %s failed: %v`, k.Action, k.Type, k.FQDN, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		revoked++
+	}
+
+	err = srv.getStruct(writer, ResponseDTO{
+		Message: fmt.Sprintf(`revoked %d rules`, revoked),
+	})
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// apiStaleRules lists allow rules that haven't matched any query in the last N days (query parameter days, default 30).
+// Rules which have never matched are stale N days after they were created.
+func (srv *Server) apiStaleRules(writer http.ResponseWriter, request *http.Request) {
+	days := 30
+
+	if v := request.URL.Query().Get(`days`); v != `` {
+		var err error
+
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 0 {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	rules, err := srv.db.Rules()
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+
+	var stale []iface.Rule
+
+	for _, r := range rules {
+		seen := r.LastSeen
+		if seen.IsZero() {
+			seen = r.Created
+		}
+
+		if r.Action == iface.ActionAllow && seen.Before(cutoff) {
+			stale = append(stale, r)
+		}
+	}
+
+	err = srv.getStruct(writer, rulesToDTO(stale))
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// apiStats returns query statistics. Query parameter window is one of 1h (default), 24h or 7d.
+func (srv *Server) apiStats(writer http.ResponseWriter, request *http.Request) {
+	window := request.URL.Query().Get(`window`)
+	if window == `` {
+		window = `1h`
+	}
+
+	d, ok := statsWindows[window]
+	if !ok {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	dto := srv.stats.Stats(d)
+	dto.Window = window
+
+	err := srv.getStruct(writer, dto)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// apiQueryLog searches the query log.
+// Query parameters since and until (RFC 3339), client, name (substring), decision, cursor and limit filter the list.
+func (srv *Server) apiQueryLog(writer http.ResponseWriter, request *http.Request) {
+	if srv.querylog == nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	q := request.URL.Query()
+
+	f := querylog.Filter{
+		Client:   q.Get(`client`),
+		Name:     q.Get(`name`),
+		Decision: q.Get(`decision`),
+		Limit:    100,
+	}
+
+	var err error
+
+	if v := q.Get(`since`); v != `` {
+		f.Since, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if v := q.Get(`until`); v != `` {
+		f.Until, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if v := q.Get(`cursor`); v != `` {
+		f.Cursor, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if v := q.Get(`limit`); v != `` {
+		f.Limit, err = strconv.Atoi(v)
+		if err != nil || f.Limit > 1000 {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, next, err := srv.querylog.Search(f)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if entries == nil {
+		entries = []querylog.Entry{}
+	}
+
+	dto := QueryLogDTO{
+		Entries: entries,
+	}
+
+	if next != 0 {
+		dto.Cursor = strconv.FormatUint(next, 10)
+	}
+
+	err = srv.getStruct(writer, dto)
+	if err != nil {
+		log.Printf(`error: %v`, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// ruleTypes gets record types of stored rule type, nil if it's unknown
+func ruleTypes(t string) []string {
+	if t != iface.TypeIP {
+		if _, ok := dns.StringToType[t]; !ok {
+			return nil
+		}
+	}
+
+	return iface.RecordTypes(t)
+}
+
+func rulesToDTO(rules []iface.Rule) []RuleDTO {
+	l := make([]RuleDTO, 0, len(rules))
+
+	for _, r := range rules {
+		dto := RuleDTO{
+			FQDN:      r.Name,
+			Type:      r.Type,
+			Action:    r.Action,
+			Scope:     `exact`,
+			Comment:   r.Comment,
+			BlockMode: r.BlockMode,
+			Hits:      r.Hits,
+		}
+
+		if r.Subtree {
+			dto.Scope = `subtree`
+		}
+
+		if !r.Expires.IsZero() {
+			dto.Expires = r.Expires.Format(time.RFC3339)
+		}
+
+		if !r.LastSeen.IsZero() {
+			dto.LastSeen = r.LastSeen.Format(time.RFC3339)
+		}
+
+		l = append(l, dto)
+	}
+
+	return l
+}
+
+// actor identifies who made the request by common name of the verified TLS client certificate.
+// Requests without one are anonymous.
+func actor(request *http.Request) string {
+	if request.TLS != nil && len(request.TLS.VerifiedChains) > 0 && len(request.TLS.VerifiedChains[0]) > 0 {
+		if cn := request.TLS.VerifiedChains[0][0].Subject.CommonName; cn != `` {
+			return cn
+		}
+	}
+
+	return `anonymous`
+}
+
+// sourceIP returns client IP address without port
+func sourceIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+
+	return host
 }
 
 func SetContentTypeMiddleware(ct string) func(http.Handler) http.Handler {
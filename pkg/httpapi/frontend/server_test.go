@@ -0,0 +1,127 @@
+package frontend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"github.com/raspi/torjuja/pkg/db/memdb"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestActor(t *testing.T) {
+	verified := func(cn string) *tls.ConnectionState {
+		return &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: cn}}}},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		state *tls.ConnectionState
+		basic string // HTTP basic auth user name, never verified
+		want  string
+	}{
+		{`plain HTTP`, nil, ``, `anonymous`},
+		{`basic auth is ignored`, nil, `admin`, `anonymous`},
+		{`TLS without client certificate`, &tls.ConnectionState{}, `admin`, `anonymous`},
+		{`unverified client certificate`, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: `mallory`}}}}, ``, `anonymous`},
+		{`verified client certificate`, verified(`alice`), `admin`, `alice`},
+		{`verified certificate without common name`, verified(``), ``, `anonymous`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(`POST`, `/api/v1/allow`, nil)
+			r.TLS = tt.state
+
+			if tt.basic != `` {
+				r.SetBasicAuth(tt.basic, `secret`)
+			}
+
+			if got := actor(r); got != tt.want {
+				t.Fatalf(`got %q, want %q`, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStaleRules(t *testing.T) {
+	db := memdb.New()
+
+	for _, name := range []string{`new.example`, `recent.example`, `old.example`} {
+		if err := db.Allow(name, `A`, iface.RuleOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Deny(`ads.example`, `A`, iface.RuleOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := db.RecordHits([]iface.Hit{
+		{Name: `recent.example`, Type: `A`, Count: 1, LastSeen: time.Now().AddDate(0, 0, -1)},
+		{Name: `old.example`, Type: `A`, Count: 1, LastSeen: time.Now().AddDate(0, 0, -40)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := New(db, nil, nil, nil, nil, nil)
+
+	tests := []struct {
+		query  string
+		status int
+		want   []string
+	}{
+		{``, http.StatusOK, []string{`old.example`}},
+		{`?days=60`, http.StatusOK, nil},
+		{`?days=0`, http.StatusOK, []string{`new.example`, `old.example`, `recent.example`}},
+		{`?days=-1`, http.StatusBadRequest, nil},
+		{`?days=many`, http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			srv.apiStaleRules(w, httptest.NewRequest(`GET`, `/api/v1/rules/stale`+tt.query, nil))
+
+			if w.Code != tt.status {
+				t.Fatalf(`got status %d, want %d`, w.Code, tt.status)
+			}
+
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var rules []RuleDTO
+
+			err := json.Unmarshal(w.Body.Bytes(), &rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, r := range rules {
+				got = append(got, r.FQDN)
+			}
+
+			sort.Strings(got)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf(`got %q, want %q`, got, tt.want)
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf(`got %q, want %q`, got, tt.want)
+				}
+			}
+		})
+	}
+}
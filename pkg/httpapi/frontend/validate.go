@@ -0,0 +1,318 @@
+package frontend
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"github.com/raspi/torjuja/pkg/idna"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const maxCommentLength = 1024
+
+// defaultAllowTypes are allowed when request doesn't list types
+var defaultAllowTypes = []string{`A`, `AAAA`}
+
+// validationErrors collects errors of request fields
+type validationErrors []FieldErrorDTO
+
+func (v *validationErrors) add(field string, format string, args ...interface{}) {
+	*v = append(*v, FieldErrorDTO{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// write responds with 400 Bad Request and the errors
+func (v validationErrors) write(srv *Server, writer http.ResponseWriter) {
+	writer.WriteHeader(http.StatusBadRequest)
+
+	err := srv.getStruct(writer, ErrorsDTO{
+		Errors: v,
+	})
+	if err != nil {
+		log.Printf(`error: %v`, err)
+	}
+}
+
+// normalizeFQDN converts name to lower case ASCII without trailing dot and checks its syntax
+func normalizeFQDN(name string) (string, error) {
+	name = strings.TrimSuffix(strings.TrimSpace(name), `.`)
+
+	if name == `` {
+		return ``, fmt.Errorf(`empty`)
+	}
+
+	name, err := idna.ToASCII(name)
+	if err != nil {
+		return ``, fmt.Errorf(`invalid internationalized name: %w`, err)
+	}
+
+	if len(name) > 253 {
+		return ``, fmt.Errorf(`longer than 253 characters`)
+	}
+
+	for _, label := range strings.Split(name, `.`) {
+		if label == `` {
+			return ``, fmt.Errorf(`empty label`)
+		}
+
+		if len(label) > 63 {
+			return ``, fmt.Errorf(`label %q is longer than 63 characters`, label)
+		}
+
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return ``, fmt.Errorf(`label %q starts or ends with hyphen`, label)
+		}
+
+		for _, c := range label {
+			// Underscore is used by SRV and other service labels
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return ``, fmt.Errorf(`label %q contains invalid character %q`, label, c)
+			}
+		}
+	}
+
+	if _, ok := dns.IsDomainName(name); !ok {
+		return ``, fmt.Errorf(`not a domain name`)
+	}
+
+	return name, nil
+}
+
+// validateAllow checks AllowDTO and converts it to the name, record types and options to store
+func validateAllow(data AllowDTO) (name string, types []string, opts iface.RuleOptions, errs validationErrors) {
+	types = defaultAllowTypes
+	if len(data.Types) > 0 {
+		types = nil
+		seen := make(map[string]bool)
+
+		for _, t := range data.Types {
+			t = strings.ToUpper(strings.TrimSpace(t))
+
+			if seen[t] {
+				continue
+			}
+
+			seen[t] = true
+
+			qtype, ok := dns.StringToType[t]
+			if !ok {
+				errs.add(`types`, `unknown record type %q`, t)
+				continue
+			}
+
+			switch qtype {
+			case dns.TypeANY, dns.TypeAXFR, dns.TypeIXFR, dns.TypeOPT, dns.TypeTSIG, dns.TypeNone, dns.TypeReserved:
+				errs.add(`types`, `record type %s can't be allowed`, t)
+				continue
+			}
+
+			types = append(types, t)
+		}
+	}
+
+	isPTR := false
+	for _, t := range types {
+		if t == `PTR` {
+			isPTR = true
+		}
+	}
+
+	if isPTR && len(types) > 1 {
+		errs.add(`types`, `PTR can't be combined with other record types`)
+	}
+
+	name = validateName(data.FQDN, isPTR, &errs)
+	opts = validateOptions(data.Scope, data.Expires, data.Comment, &errs)
+
+	if opts.Subtree && publicSuffix(name) {
+		errs.add(`scope`, `subtree of public suffix %q can't be allowed`, name)
+	}
+
+	return name, types, opts, errs
+}
+
+// validateRule checks RuleDTO and converts it to the name, record types and options to store
+func validateRule(data RuleDTO) (name string, types []string, opts iface.RuleOptions, errs validationErrors) {
+	switch data.Action {
+	case iface.ActionAllow, iface.ActionDeny:
+	default:
+		errs.add(`action`, `action must be allow or deny`)
+	}
+
+	types = ruleTypes(data.Type)
+	if types == nil {
+		errs.add(`type`, `unknown rule type %q`, data.Type)
+	}
+
+	name = validateName(data.FQDN, data.Type == `PTR`, &errs)
+	opts = validateOptions(data.Scope, data.Expires, data.Comment, &errs)
+
+	if data.Action == iface.ActionAllow && opts.Subtree && publicSuffix(name) {
+		errs.add(`scope`, `subtree of public suffix %q can't be allowed`, name)
+	}
+
+	opts.BlockMode = data.BlockMode
+	if opts.BlockMode != `` {
+		switch {
+		case data.Action != iface.ActionDeny:
+			errs.add(`block_mode`, `block mode can only be set on deny rules`)
+		case !iface.ValidBlockMode(opts.BlockMode):
+			errs.add(`block_mode`, `unknown block mode %q`, opts.BlockMode)
+		}
+	}
+
+	return name, types, opts, errs
+}
+
+// validateRuleKeys checks keys of rules to revoke and normalizes their names
+func validateRuleKeys(keys []RuleKeyDTO) (l []RuleKeyDTO, errs validationErrors) {
+	for i, k := range keys {
+		switch k.Action {
+		case iface.ActionAllow, iface.ActionDeny:
+		default:
+			errs.add(fmt.Sprintf(`rules[%d].action`, i), `action must be allow or deny`)
+		}
+
+		if ruleTypes(k.Type) == nil {
+			errs.add(fmt.Sprintf(`rules[%d].type`, i), `unknown rule type %q`, k.Type)
+		}
+
+		var nameErrs validationErrors
+		k.FQDN = validateName(k.FQDN, k.Type == `PTR`, &nameErrs)
+
+		for _, e := range nameErrs {
+			errs.add(fmt.Sprintf(`rules[%d].fqdn`, i), `%s`, e.Message)
+		}
+
+		l = append(l, k)
+	}
+
+	return l, errs
+}
+
+// secondLevelSuffixes are labels under country code top level domains which are registries of their own, such as co in co.uk
+var secondLevelSuffixes = map[string]bool{
+	`ac`: true, `co`: true, `com`: true, `edu`: true, `gen`: true, `go`: true, `gob`: true, `gov`: true, `ltd`: true,
+	`mil`: true, `ne`: true, `net`: true, `nom`: true, `or`: true, `org`: true, `plc`: true, `sch`: true,
+}
+
+// publicSuffix tells if name is a top level domain or a registry under a country code top level domain such as co.uk.
+// Names under them belong to unrelated parties.
+func publicSuffix(name string) bool {
+	labels := strings.Split(name, `.`)
+
+	switch len(labels) {
+	case 1:
+		return true
+	case 2:
+		return len(labels[1]) == 2 && secondLevelSuffixes[labels[0]]
+	default:
+		return false
+	}
+}
+
+// reverseIP gets the address of reverse lookup name such as 1.2.0.192.in-addr.arpa, nil if name isn't one
+func reverseIP(name string) net.IP {
+	name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), `.`))
+
+	if labels, ok := trimLabels(name, `.in-addr.arpa`, 4); ok {
+		return net.ParseIP(strings.Join(labels, `.`)).To4()
+	}
+
+	if labels, ok := trimLabels(name, `.ip6.arpa`, 32); ok {
+		var b strings.Builder
+
+		for i, nibble := range labels {
+			if len(nibble) != 1 {
+				return nil
+			}
+
+			if i > 0 && i%4 == 0 {
+				b.WriteByte(':')
+			}
+
+			b.WriteString(nibble)
+		}
+
+		return net.ParseIP(b.String())
+	}
+
+	return nil
+}
+
+// trimLabels removes suffix from name and returns its n labels in reverse order
+func trimLabels(name string, suffix string, n int) (labels []string, ok bool) {
+	if !strings.HasSuffix(name, suffix) {
+		return nil, false
+	}
+
+	labels = strings.Split(strings.TrimSuffix(name, suffix), `.`)
+	if len(labels) != n {
+		return nil, false
+	}
+
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+
+	return labels, true
+}
+
+// validateName checks and normalizes name of a rule. PTR rules are stored by the address being looked up.
+func validateName(fqdn string, isPTR bool, errs *validationErrors) string {
+	if isPTR {
+		ip := net.ParseIP(strings.TrimSpace(fqdn))
+		if ip == nil {
+			ip = reverseIP(fqdn)
+		}
+
+		if ip == nil {
+			errs.add(`fqdn`, `PTR rule needs an IP address or reverse lookup name`)
+			return ``
+		}
+
+		return ip.String()
+	}
+
+	name, err := normalizeFQDN(fqdn)
+	if err != nil {
+		errs.add(`fqdn`, `%v`, err)
+	}
+
+	return name
+}
+
+// validateOptions checks scope, expiry time and comment of a rule
+func validateOptions(scope string, expires string, comment string, errs *validationErrors) (opts iface.RuleOptions) {
+	switch scope {
+	case ``, `exact`:
+	case `subtree`:
+		opts.Subtree = true
+	default:
+		errs.add(`scope`, `scope must be exact or subtree`)
+	}
+
+	if expires != `` {
+		var err error
+
+		opts.Expires, err = time.Parse(time.RFC3339, expires)
+		if err != nil {
+			errs.add(`expires`, `not RFC 3339 time`)
+		} else if opts.Expires.Before(time.Now()) {
+			errs.add(`expires`, `already expired`)
+		}
+	}
+
+	opts.Comment = strings.TrimSpace(comment)
+	if len(opts.Comment) > maxCommentLength {
+		errs.add(`comment`, `longer than %d characters`, maxCommentLength)
+	}
+
+	return opts
+}
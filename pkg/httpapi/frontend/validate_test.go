@@ -0,0 +1,122 @@
+package frontend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateRuleKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		key  RuleKeyDTO
+		fqdn string // Normalized name, empty if the key is invalid
+	}{
+		{`exact`, RuleKeyDTO{FQDN: `example.com`, Type: `IP`, Action: `allow`}, `example.com`},
+		{`normalized`, RuleKeyDTO{FQDN: `Example.COM.`, Type: `TXT`, Action: `deny`}, `example.com`},
+		{`ptr`, RuleKeyDTO{FQDN: `192.0.2.1`, Type: `PTR`, Action: `allow`}, `192.0.2.1`},
+		{`ptr needs address`, RuleKeyDTO{FQDN: `example.com`, Type: `PTR`, Action: `allow`}, ``},
+		{`traversal in name`, RuleKeyDTO{FQDN: `../../etc`, Type: `IP`, Action: `allow`}, ``},
+		{`traversal in type`, RuleKeyDTO{FQDN: `example.com`, Type: `../IP`, Action: `allow`}, ``},
+		{`unknown type`, RuleKeyDTO{FQDN: `example.com`, Type: `BOGUS`, Action: `allow`}, ``},
+		{`unknown action`, RuleKeyDTO{FQDN: `example.com`, Type: `IP`, Action: `bogus`}, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, errs := validateRuleKeys([]RuleKeyDTO{tt.key})
+
+			if tt.fqdn == `` {
+				if len(errs) == 0 {
+					t.Fatalf(`no errors for %+v`, tt.key)
+				}
+
+				return
+			}
+
+			if len(errs) > 0 {
+				t.Fatalf(`errors: %+v`, errs)
+			}
+
+			if keys[0].FQDN != tt.fqdn {
+				t.Fatalf(`got name %q, want %q`, keys[0].FQDN, tt.fqdn)
+			}
+		})
+	}
+}
+
+func TestValidateRule(t *testing.T) {
+	tomorrow := time.Now().Add(24 * time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		name  string
+		rule  RuleDTO
+		fqdn  string
+		field string // Field of the first error, empty if valid
+	}{
+		{`allow`, RuleDTO{FQDN: `Example.com`, Type: `A`, Action: `allow`}, `example.com`, ``},
+		{`subtree deny`, RuleDTO{FQDN: `ads.example.com`, Type: `IP`, Action: `deny`, Scope: `subtree`, Expires: tomorrow, BlockMode: `nxdomain`}, `ads.example.com`, ``},
+		{`idn`, RuleDTO{FQDN: `bücher.example`, Type: `A`, Action: `allow`}, `xn--bcher-kva.example`, ``},
+		{`ptr`, RuleDTO{FQDN: `2001:DB8::1`, Type: `PTR`, Action: `allow`}, `2001:db8::1`, ``},
+		{`subtree allow`, RuleDTO{FQDN: `bar.co.uk`, Type: `IP`, Action: `allow`, Scope: `subtree`}, `bar.co.uk`, ``},
+		{`subtree deny of public suffix`, RuleDTO{FQDN: `zip`, Type: `IP`, Action: `deny`, Scope: `subtree`}, `zip`, ``},
+		{`subtree allow of top level domain`, RuleDTO{FQDN: `com`, Type: `IP`, Action: `allow`, Scope: `subtree`}, ``, `scope`},
+		{`subtree allow of public suffix`, RuleDTO{FQDN: `co.uk`, Type: `IP`, Action: `allow`, Scope: `subtree`}, ``, `scope`},
+		{`empty name`, RuleDTO{Type: `A`, Action: `allow`}, ``, `fqdn`},
+		{`invalid name`, RuleDTO{FQDN: `a/b.example.com`, Type: `A`, Action: `allow`}, ``, `fqdn`},
+		{`ptr reverse name`, RuleDTO{FQDN: `1.2.0.192.IN-ADDR.ARPA.`, Type: `PTR`, Action: `allow`}, `192.0.2.1`, ``},
+		{`ptr ipv6 reverse name`, RuleDTO{FQDN: `1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa`, Type: `PTR`, Action: `allow`}, `2001:db8::1`, ``},
+		{`ptr needs address`, RuleDTO{FQDN: `example.com`, Type: `PTR`, Action: `allow`}, ``, `fqdn`},
+		{`ptr partial reverse name`, RuleDTO{FQDN: `2.0.192.in-addr.arpa`, Type: `PTR`, Action: `allow`}, ``, `fqdn`},
+		{`unknown type`, RuleDTO{FQDN: `example.com`, Type: `../A`, Action: `allow`}, ``, `type`},
+		{`unknown action`, RuleDTO{FQDN: `example.com`, Type: `A`, Action: `bogus`}, ``, `action`},
+		{`unknown scope`, RuleDTO{FQDN: `example.com`, Type: `A`, Action: `allow`, Scope: `bogus`}, ``, `scope`},
+		{`block mode on allow`, RuleDTO{FQDN: `example.com`, Type: `A`, Action: `allow`, BlockMode: `nxdomain`}, ``, `block_mode`},
+		{`unknown block mode`, RuleDTO{FQDN: `example.com`, Type: `A`, Action: `deny`, BlockMode: `bogus`}, ``, `block_mode`},
+		{`invalid expiry`, RuleDTO{FQDN: `example.com`, Type: `A`, Action: `allow`, Expires: `tomorrow`}, ``, `expires`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, _, _, errs := validateRule(tt.rule)
+
+			if tt.field != `` {
+				if len(errs) == 0 || errs[0].Field != tt.field {
+					t.Fatalf(`got errors %+v, want error of field %s`, errs, tt.field)
+				}
+
+				return
+			}
+
+			if len(errs) > 0 {
+				t.Fatalf(`errors: %+v`, errs)
+			}
+
+			if name != tt.fqdn {
+				t.Fatalf(`got name %q, want %q`, name, tt.fqdn)
+			}
+		})
+	}
+}
+
+func TestPublicSuffix(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{`com`, true},
+		{`co.uk`, true},
+		{`com.au`, true},
+		{`example.com`, false},
+		{`bar.co.uk`, false},
+		{`co.example`, false},
+		{`com.example.org`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := publicSuffix(tt.name); got != tt.want {
+				t.Fatalf(`got %v, want %v`, got, tt.want)
+			}
+		})
+	}
+}
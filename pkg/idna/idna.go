@@ -0,0 +1,163 @@
+package idna
+
+/*
+Conversion of internationalized domain names to ASCII (punycode, RFC 3492).
+Only lower casing is done as mapping, full UTS #46 mapping is not implemented.
+*/
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const acePrefix = `xn--`
+
+// Punycode parameters
+const (
+	base        = 36
+	tmin        = 1
+	tmax        = 26
+	skew        = 38
+	damp        = 700
+	initialBias = 72
+	initialN    = 128
+)
+
+// ToASCII converts every non-ASCII label of name to punycode
+func ToASCII(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return ``, fmt.Errorf(`invalid UTF-8`)
+	}
+
+	labels := strings.Split(strings.ToLower(name), `.`)
+
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+
+		enc, err := encode(label)
+		if err != nil {
+			return ``, fmt.Errorf(`label %q: %w`, label, err)
+		}
+
+		labels[i] = acePrefix + enc
+	}
+
+	return strings.Join(labels, `.`), nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
+
+// encode encodes label with punycode without the ACE prefix
+func encode(label string) (string, error) {
+	runes := []rune(label)
+	var out []byte
+
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+
+	basic := len(out)
+	handled := basic
+
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n := rune(initialN)
+	delta := 0
+	bias := initialBias
+
+	for handled < len(runes) {
+		// Smallest code point not yet handled
+		m := rune(utf8.MaxRune)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+
+		delta += int(m-n) * (handled + 1)
+		if delta < 0 {
+			return ``, fmt.Errorf(`overflow`)
+		}
+
+		n = m
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+
+			if r != n {
+				continue
+			}
+
+			q := delta
+
+			for k := base; ; k += base {
+				t := k - bias
+				if t < tmin {
+					t = tmin
+				} else if t > tmax {
+					t = tmax
+				}
+
+				if q < t {
+					break
+				}
+
+				out = append(out, digit(t+(q-t)%(base-t)))
+				q = (q - t) / (base - t)
+			}
+
+			out = append(out, digit(q))
+			bias = adapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+
+		delta++
+		n++
+	}
+
+	return string(out), nil
+}
+
+func adapt(delta int, points int, first bool) int {
+	if first {
+		delta /= damp
+	} else {
+		delta /= 2
+	}
+
+	delta += delta / points
+	k := 0
+
+	for delta > ((base-tmin)*tmax)/2 {
+		delta /= base - tmin
+		k += base
+	}
+
+	return k + (base-tmin+1)*delta/(delta+skew)
+}
+
+func digit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+
+	return byte('0' + d - 26)
+}
@@ -0,0 +1,48 @@
+package idna
+
+import (
+	"testing"
+)
+
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{`example.com`, `example.com`},
+		{`Example.COM`, `example.com`},
+		{``, ``},
+		{`bücher`, `xn--bcher-kva`},
+		{`Bücher.example`, `xn--bcher-kva.example`},
+		{`münchen.de`, `xn--mnchen-3ya.de`},
+		{`münchen-ost.de`, `xn--mnchen-ost-9db.de`},
+		{`www.例え.テスト`, `www.xn--r8jz45g.xn--zckzah`},
+		{`ü`, `xn--tda`},
+		// RFC 3492 section 7.1 samples
+		{`ليهمابتكلموشعربي؟`, `xn--egbpdaj6bu4bxfgehfvwxn`},
+		{`他们为什么不说中文`, `xn--ihqwcrb4cv8a8dqg056pqjye`},
+		{`3年B組金八先生`, `xn--3b-ww4c5e180e575a65lsy2b`},
+		{`安室奈美恵-with-super-monkeys`, `xn---with-super-monkeys-pc58ag80a8qai00g7n9n`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToASCII(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Fatalf(`got %q, want %q`, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToASCIIInvalid(t *testing.T) {
+	for _, name := range []string{"\xff.example", "b\xc3cher"} {
+		if got, err := ToASCII(name); err == nil {
+			t.Errorf(`%q: got %q, want error`, name, got)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package localdata
+
+/*
+Locally defined DNS records which are answered before allow rules are checked
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/miekg/dns"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// maxChain is the maximum number of local CNAMEs followed
+const maxChain = 8
+
+// Answer is the local answer to a question
+type Answer struct {
+	Rcode  int
+	Answer []dns.RR
+	Ns     []dns.RR
+	Target string // CNAME target outside local data which still needs to be resolved, empty if none
+}
+
+// Record is a single local record and where it's defined
+type Record struct {
+	RR   dns.RR
+	File string // Zone file or source, empty for records managed through Data.Add
+}
+
+// Data holds local records. Names under a zone that has a SOA record get NXDOMAIN if they don't exist.
+type Data struct {
+	mu      sync.RWMutex
+	static  []Record            // From zone files
+	sources map[string][]dns.RR // Replaced as a whole with SetSource, for example hosts and lease files
+	dynamic []dns.RR            // Managed through Add and Remove
+	path    string              // Zone file dynamic records are stored in, empty if Add and Remove are disabled
+	records map[string]map[uint16][]dns.RR
+	zones   map[string]*dns.SOA // Zone name -> SOA
+}
+
+// New reads zone files and records managed through the API from p. Empty p disables Add and Remove.
+func New(files []string, p string) (*Data, error) {
+	if p != `` && !path.IsAbs(p) {
+		return nil, fmt.Errorf(`not absolute path: %q`, p)
+	}
+
+	d := &Data{
+		path:    p,
+		sources: make(map[string][]dns.RR),
+	}
+
+	for _, f := range files {
+		rrs, err := parseFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rr := range rrs {
+			d.static = append(d.static, Record{RR: rr, File: f})
+		}
+	}
+
+	if p != `` {
+		rrs, err := parseFile(p)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		d.dynamic = rrs
+	}
+
+	d.rebuild()
+
+	return d, nil
+}
+
+func parseFile(p string) ([]dns.RR, error) {
+	fh, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	return parse(fh, p)
+}
+
+func parse(r io.Reader, file string) (rrs []dns.RR, err error) {
+	zp := dns.NewZoneParser(r, ``, file)
+
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+
+	if err = zp.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, rr := range rrs {
+		if err = check(rr); err != nil {
+			return nil, fmt.Errorf(`%s: %w`, file, err)
+		}
+	}
+
+	return rrs, nil
+}
+
+// check tells if rr is a supported record
+func check(rr dns.RR) error {
+	switch rr.Header().Rrtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypeCNAME, dns.TypeTXT, dns.TypeSRV, dns.TypePTR, dns.TypeMX, dns.TypeSOA, dns.TypeNS, dns.TypeCAA, dns.TypeHTTPS, dns.TypeSVCB:
+	default:
+		return fmt.Errorf(`unsupported record type %s`, dns.TypeToString[rr.Header().Rrtype])
+	}
+
+	if rr.Header().Class != dns.ClassINET {
+		return fmt.Errorf(`unsupported class %s`, dns.ClassToString[rr.Header().Class])
+	}
+
+	return nil
+}
+
+// rebuild indexes records by name and type and generates PTR records for addresses.
+// Caller must hold the write lock.
+func (d *Data) rebuild() {
+	d.records = make(map[string]map[uint16][]dns.RR)
+	d.zones = make(map[string]*dns.SOA)
+
+	all := make([]dns.RR, 0, len(d.static)+len(d.dynamic))
+	for _, r := range d.static {
+		all = append(all, r.RR)
+	}
+
+	for _, rrs := range d.sources {
+		all = append(all, rrs...)
+	}
+
+	all = append(all, d.dynamic...)
+
+	// Names with PTR records defined, generated PTR records are not added for them
+	explicit := make(map[string]bool)
+
+	for _, rr := range all {
+		d.add(rr)
+
+		switch r := rr.(type) {
+		case *dns.SOA:
+			d.zones[strings.ToLower(r.Hdr.Name)] = r
+		case *dns.PTR:
+			explicit[strings.ToLower(r.Hdr.Name)] = true
+		}
+	}
+
+	// Automatic PTR records for addresses
+	generated := make(map[string]bool)
+
+	for _, rr := range all {
+		var ip net.IP
+
+		switch r := rr.(type) {
+		case *dns.A:
+			ip = r.A
+		case *dns.AAAA:
+			ip = r.AAAA
+		default:
+			continue
+		}
+
+		rev, err := dns.ReverseAddr(ip.String())
+		if err != nil {
+			continue
+		}
+
+		target := strings.ToLower(rr.Header().Name)
+
+		if explicit[rev] || generated[rev+` `+target] {
+			continue
+		}
+
+		generated[rev+` `+target] = true
+
+		d.add(&dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   rev,
+				Rrtype: dns.TypePTR,
+				Class:  dns.ClassINET,
+				Ttl:    rr.Header().Ttl,
+			},
+			Ptr: target,
+		})
+	}
+}
+
+func (d *Data) add(rr dns.RR) {
+	name := strings.ToLower(rr.Header().Name)
+
+	types, ok := d.records[name]
+	if !ok {
+		types = make(map[uint16][]dns.RR)
+		d.records[name] = types
+	}
+
+	t := rr.Header().Rrtype
+	types[t] = append(types[t], rr)
+}
+
+// zone finds zone name is in, nil if none
+func (d *Data) zone(name string) *dns.SOA {
+	for {
+		if soa, ok := d.zones[name]; ok {
+			return soa
+		}
+
+		i := strings.IndexByte(name, '.')
+		if i < 0 || i == len(name)-1 {
+			return nil
+		}
+
+		name = name[i+1:]
+	}
+}
+
+// Lookup answers question q from local data. ok is false if the question isn't answered locally.
+func (d *Data) Lookup(q dns.Question) (a Answer, ok bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	name := strings.ToLower(dns.Fqdn(q.Name))
+
+	for i := 0; i < maxChain; i++ {
+		types, found := d.records[name]
+		if !found {
+			if i > 0 {
+				// CNAME target is not local
+				a.Target = name
+				return a, true
+			}
+
+			soa := d.zone(name)
+			if soa == nil {
+				return a, false
+			}
+
+			a.Rcode = dns.RcodeNameError
+			a.Ns = []dns.RR{dns.Copy(soa)}
+			return a, true
+		}
+
+		if rrs, found := types[q.Qtype]; found {
+			a.Answer = append(a.Answer, copyAll(rrs)...)
+			return a, true
+		}
+
+		cname, found := types[dns.TypeCNAME]
+		if !found {
+			// NODATA
+			if soa := d.zone(name); soa != nil {
+				a.Ns = []dns.RR{dns.Copy(soa)}
+			}
+
+			return a, true
+		}
+
+		a.Answer = append(a.Answer, dns.Copy(cname[0]))
+		name = strings.ToLower(cname[0].(*dns.CNAME).Target)
+	}
+
+	// CNAME loop or too long chain
+	a.Rcode = dns.RcodeServerFailure
+	a.Answer = nil
+	return a, true
+}
+
+func copyAll(rrs []dns.RR) []dns.RR {
+	l := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		l = append(l, dns.Copy(rr))
+	}
+
+	return l
+}
+
+// Records lists all records except generated PTR records
+func (d *Data) Records() []Record {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	l := make([]Record, 0, len(d.static)+len(d.dynamic))
+	l = append(l, d.static...)
+
+	for name, rrs := range d.sources {
+		for _, rr := range rrs {
+			l = append(l, Record{RR: rr, File: name})
+		}
+	}
+
+	for _, rr := range d.dynamic {
+		l = append(l, Record{RR: rr})
+	}
+
+	return l
+}
+
+// SetSource replaces records of source name
+func (d *Data) SetSource(name string, rrs []dns.RR) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(rrs) == 0 {
+		delete(d.sources, name)
+	} else {
+		d.sources[name] = rrs
+	}
+
+	d.rebuild()
+}
+
+// Hostname gets local name of address ip, empty if it has none
+func (d *Data) Hostname(ip net.IP) string {
+	rev, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return ``
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	ptrs := d.records[rev][dns.TypePTR]
+	if len(ptrs) == 0 {
+		return ``
+	}
+
+	return strings.TrimSuffix(ptrs[0].(*dns.PTR).Ptr, `.`)
+}
+
+// Parse parses a single record in zone file format, for example "nas.lan. 300 IN A 192.168.1.2"
+func Parse(s string) (dns.RR, error) {
+	rrs, err := parse(strings.NewReader(s), ``)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rrs) != 1 {
+		return nil, fmt.Errorf(`expected one record, got %d`, len(rrs))
+	}
+
+	return rrs[0], nil
+}
+
+// Add adds record and stores records managed through the API
+func (d *Data) Add(rr dns.RR) error {
+	if d.path == `` {
+		return fmt.Errorf(`records can't be added, local data is not configured`)
+	}
+
+	err := check(rr)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, r := range d.dynamic {
+		if dns.IsDuplicate(r, rr) {
+			return nil
+		}
+	}
+
+	d.dynamic = append(d.dynamic, rr)
+	d.rebuild()
+
+	return d.save()
+}
+
+// Remove removes record managed through the API. ok is false if there's no such record.
+func (d *Data) Remove(rr dns.RR) (ok bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, r := range d.dynamic {
+		if !dns.IsDuplicate(r, rr) {
+			continue
+		}
+
+		d.dynamic = append(d.dynamic[:i], d.dynamic[i+1:]...)
+		d.rebuild()
+
+		return true, d.save()
+	}
+
+	return false, nil
+}
+
+// save writes records managed through the API to d.path. Caller must hold the lock.
+func (d *Data) save() error {
+	var buf bytes.Buffer
+
+	for _, rr := range d.dynamic {
+		buf.WriteString(rr.String())
+		buf.WriteByte('\n')
+	}
+
+	tmp := d.path + `.tmp`
+
+	err := os.WriteFile(tmp, buf.Bytes(), 0640)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, d.path)
+}
@@ -0,0 +1,236 @@
+package localdata
+
+import (
+	"github.com/miekg/dns"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+const testZone = `$TTL 300
+lan. IN SOA ns.lan. hostmaster.lan. 1 3600 600 86400 60
+nas.lan. IN A 192.168.1.2
+nas.lan. IN AAAA fd00::2
+NAS2.lan. IN A 192.168.1.3
+www.lan. IN CNAME nas.lan.
+files.lan. IN CNAME www.lan.
+ext.lan. IN CNAME example.com.
+loop1.lan. IN CNAME loop2.lan.
+loop2.lan. IN CNAME loop1.lan.
+printer.lan. IN A 192.168.1.4
+4.1.168.192.in-addr.arpa. IN PTR laserjet.lan.
+_ipp._tcp.lan. IN SRV 0 0 631 printer.lan.
+router.home. IN A 192.168.1.1
+`
+
+func newTestData(t *testing.T) *Data {
+	t.Helper()
+
+	dir := t.TempDir()
+	p := path.Join(dir, `lan.zone`)
+
+	err := os.WriteFile(p, []byte(testZone), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := New([]string{p}, path.Join(dir, `dynamic.zone`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func answerStrings(rrs []dns.RR) (l []string) {
+	for _, rr := range rrs {
+		l = append(l, rr.String())
+	}
+
+	return l
+}
+
+func TestLookup(t *testing.T) {
+	d := newTestData(t)
+
+	tests := []struct {
+		name   string
+		qtype  uint16
+		ok     bool
+		rcode  int
+		answer []string
+		ns     bool // Authority section has the SOA
+		target string
+	}{
+		{`nas.lan.`, dns.TypeA, true, dns.RcodeSuccess, []string{"nas.lan.\t300\tIN\tA\t192.168.1.2"}, false, ``},
+		{`NAS.LAN`, dns.TypeAAAA, true, dns.RcodeSuccess, []string{"nas.lan.\t300\tIN\tAAAA\tfd00::2"}, false, ``},
+		{`nas2.lan.`, dns.TypeA, true, dns.RcodeSuccess, []string{"NAS2.lan.\t300\tIN\tA\t192.168.1.3"}, false, ``},
+		{`nas.lan.`, dns.TypeMX, true, dns.RcodeSuccess, nil, true, ``},
+		{`missing.lan.`, dns.TypeA, true, dns.RcodeNameError, nil, true, ``},
+		{`files.lan.`, dns.TypeA, true, dns.RcodeSuccess, []string{
+			"files.lan.\t300\tIN\tCNAME\twww.lan.",
+			"www.lan.\t300\tIN\tCNAME\tnas.lan.",
+			"nas.lan.\t300\tIN\tA\t192.168.1.2",
+		}, false, ``},
+		{`www.lan.`, dns.TypeCNAME, true, dns.RcodeSuccess, []string{"www.lan.\t300\tIN\tCNAME\tnas.lan."}, false, ``},
+		{`ext.lan.`, dns.TypeA, true, dns.RcodeSuccess, []string{"ext.lan.\t300\tIN\tCNAME\texample.com."}, false, `example.com.`},
+		{`loop1.lan.`, dns.TypeA, true, dns.RcodeServerFailure, nil, false, ``},
+		{`_ipp._tcp.lan.`, dns.TypeSRV, true, dns.RcodeSuccess, []string{"_ipp._tcp.lan.\t300\tIN\tSRV\t0 0 631 printer.lan."}, false, ``},
+		{`router.home.`, dns.TypeA, true, dns.RcodeSuccess, []string{"router.home.\t300\tIN\tA\t192.168.1.1"}, false, ``},
+		{`other.home.`, dns.TypeA, false, 0, nil, false, ``},
+		{`example.com.`, dns.TypeA, false, 0, nil, false, ``},
+		// Generated PTR records
+		{`2.1.168.192.in-addr.arpa.`, dns.TypePTR, true, dns.RcodeSuccess, []string{"2.1.168.192.in-addr.arpa.\t300\tIN\tPTR\tnas.lan."}, false, ``},
+		{`1.1.168.192.in-addr.arpa.`, dns.TypePTR, true, dns.RcodeSuccess, []string{"1.1.168.192.in-addr.arpa.\t300\tIN\tPTR\trouter.home."}, false, ``},
+		// Explicit PTR wins over generated
+		{`4.1.168.192.in-addr.arpa.`, dns.TypePTR, true, dns.RcodeSuccess, []string{"4.1.168.192.in-addr.arpa.\t300\tIN\tPTR\tlaserjet.lan."}, false, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+` `+dns.Type(tt.qtype).String(), func(t *testing.T) {
+			a, ok := d.Lookup(dns.Question{Name: tt.name, Qtype: tt.qtype, Qclass: dns.ClassINET})
+
+			if ok != tt.ok {
+				t.Fatalf(`got ok %v, want %v`, ok, tt.ok)
+			}
+
+			if !ok {
+				return
+			}
+
+			if a.Rcode != tt.rcode {
+				t.Fatalf(`got rcode %s, want %s`, dns.RcodeToString[a.Rcode], dns.RcodeToString[tt.rcode])
+			}
+
+			if got := answerStrings(a.Answer); !reflect.DeepEqual(got, tt.answer) {
+				t.Fatalf(`got answer %q, want %q`, got, tt.answer)
+			}
+
+			if (len(a.Ns) == 1) != tt.ns {
+				t.Fatalf(`got authority %v`, a.Ns)
+			}
+
+			if a.Target != tt.target {
+				t.Fatalf(`got target %q, want %q`, a.Target, tt.target)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		record string
+		valid  bool
+	}{
+		{`nas.lan. 300 IN A 192.168.1.2`, true},
+		{`nas.lan. IN AAAA fd00::2`, true},
+		{`lan. IN MX 10 mail.lan.`, true},
+		{`lan. IN TXT "v=spf1 -all"`, true},
+		{`lan. IN CAA 0 issue "letsencrypt.org"`, true},
+		{`nas.lan. IN A 300.1.1.1`, false},
+		{`nas.lan. IN HINFO "cpu" "os"`, false},
+		{`nas.lan. CH A 192.168.1.2`, false},
+		{"nas.lan. IN A 192.168.1.2\nnas.lan. IN A 192.168.1.3", false},
+		{``, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.record, func(t *testing.T) {
+			_, err := Parse(tt.record)
+			if (err == nil) != tt.valid {
+				t.Fatalf(`got error %v, want valid %v`, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	d := newTestData(t)
+
+	rr, err := Parse(`tv.lan. 60 IN A 192.168.1.50`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = d.Add(rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Duplicates are ignored
+	err = d.Add(rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h := d.Hostname(net.ParseIP(`192.168.1.50`)); h != `tv.lan` {
+		t.Fatalf(`got host name %q`, h)
+	}
+
+	// Records are kept over restarts
+	d2, err := New(nil, d.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(d2.Records()); got != 1 {
+		t.Fatalf(`got %d records after reopen, want 1`, got)
+	}
+
+	ok, err := d.Remove(rr)
+	if err != nil || !ok {
+		t.Fatalf(`remove: %v %v`, ok, err)
+	}
+
+	ok, err = d.Remove(rr)
+	if err != nil || ok {
+		t.Fatalf(`second remove: %v %v`, ok, err)
+	}
+
+	if _, found := d.Lookup(dns.Question{Name: `tv.lan.`, Qtype: dns.TypeA, Qclass: dns.ClassINET}); !found {
+		t.Fatal(`name in zone lan. not answered`)
+	}
+
+	if h := d.Hostname(net.ParseIP(`192.168.1.50`)); h != `` {
+		t.Fatalf(`got host name %q after remove`, h)
+	}
+}
+
+func TestAddDisabled(t *testing.T) {
+	d, err := New(nil, ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := Parse(`tv.lan. 60 IN A 192.168.1.50`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Add(rr) == nil {
+		t.Fatal(`added without storage path`)
+	}
+}
+
+func TestSetSource(t *testing.T) {
+	d := newTestData(t)
+
+	rr, err := Parse(`laptop.home. 60 IN A 192.168.1.60`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.SetSource(`leases`, []dns.RR{rr})
+
+	if h := d.Hostname(net.ParseIP(`192.168.1.60`)); h != `laptop.home` {
+		t.Fatalf(`got host name %q`, h)
+	}
+
+	d.SetSource(`leases`, nil)
+
+	if _, ok := d.Lookup(dns.Question{Name: `laptop.home.`, Qtype: dns.TypeA, Qclass: dns.ClassINET}); ok {
+		t.Fatal(`source records not replaced`)
+	}
+}
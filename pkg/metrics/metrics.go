@@ -0,0 +1,259 @@
+package metrics
+
+/*
+Minimal Prometheus text exposition format metrics
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// DefaultBuckets are histogram upper bounds in seconds suitable for network requests
+	DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+	// FastBuckets are histogram upper bounds in seconds suitable for local lookups
+	FastBuckets = []float64{.00001, .000025, .00005, .0001, .00025, .0005, .001, .0025, .005, .01, .05}
+)
+
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry holds all metrics and serves them over HTTP
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// ServeHTTP writes all metrics in Prometheus text format
+func (r *Registry) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set(`Content-Type`, `text/plain; version=0.0.4; charset=utf-8`)
+	_ = r.Write(writer)
+}
+
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+
+	for _, c := range r.collectors {
+		c.write(bw)
+	}
+
+	return bw.Flush()
+}
+
+// vec holds label names and ordered series keys
+type vec struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (v vec) header(w io.Writer, t string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	_, _ = fmt.Fprintf(w, "# TYPE %s %s\n", v.name, t)
+}
+
+// key joins label values to a map key
+func (v vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf(`metrics: %s expects %d label values, got %d`, v.name, len(v.labels), len(values)))
+	}
+
+	return strings.Join(values, "\x00")
+}
+
+// labelString formats label pairs, extra is appended as is
+func (v vec) labelString(key string, extra string) string {
+	var pairs []string
+
+	if len(v.labels) > 0 {
+		for i, val := range strings.Split(key, "\x00") {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, v.labels[i], escape(val)))
+		}
+	}
+
+	if extra != `` {
+		pairs = append(pairs, extra)
+	}
+
+	if len(pairs) == 0 {
+		return ``
+	}
+
+	return `{` + strings.Join(pairs, `,`) + `}`
+}
+
+func escape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return `+Inf`
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// CounterVec is a monotonically increasing counter partitioned by labels
+type CounterVec struct {
+	vec
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		vec:    vec{name: name, help: help, labels: labels},
+		values: make(map[string]float64),
+	}
+
+	r.register(c)
+
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	k := c.key(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[k] += v
+}
+
+// Value returns current value of the counter
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	k := c.key(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[k]
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w, `counter`)
+
+	for _, k := range sortedKeys(c.values) {
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(k, ``), formatFloat(c.values[k]))
+	}
+}
+
+// HistogramVec counts observations in buckets partitioned by labels
+type HistogramVec struct {
+	vec
+	mu      sync.Mutex
+	buckets []float64
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		vec:     vec{name: name, help: help, labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+
+	r.register(h)
+
+	return h
+}
+
+// Observe adds value v (seconds) to the histogram
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	k := h.key(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+			break
+		}
+	}
+
+	s.count++
+	s.sum += v
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w, `histogram`)
+
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := h.series[k]
+
+		var cumulative uint64
+
+		for i, b := range h.buckets {
+			cumulative += s.counts[i]
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(k, fmt.Sprintf(`le="%s"`, formatFloat(b))), cumulative)
+		}
+
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(k, `le="+Inf"`), s.count)
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(k, ``), formatFloat(s.sum))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(k, ``), s.count)
+	}
+}
@@ -0,0 +1,172 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		fill func(r *Registry)
+		want string
+	}{
+		{
+			`counter without labels`,
+			func(r *Registry) {
+				c := r.NewCounterVec(`requests_total`, `Requests.`)
+				c.Inc()
+				c.Add(2.5)
+			},
+			`# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total 3.5
+`,
+		},
+		{
+			`counter series sorted by labels`,
+			func(r *Registry) {
+				c := r.NewCounterVec(`queries_total`, `Queries.`, `type`, `decision`)
+				c.Inc(`AAAA`, `allowed`)
+				c.Inc(`A`, `blocked`)
+				c.Inc(`A`, `allowed`)
+				c.Inc(`A`, `allowed`)
+			},
+			`# HELP queries_total Queries.
+# TYPE queries_total counter
+queries_total{type="A",decision="allowed"} 2
+queries_total{type="A",decision="blocked"} 1
+queries_total{type="AAAA",decision="allowed"} 1
+`,
+		},
+		{
+			`label values escaped`,
+			func(r *Registry) {
+				r.NewCounterVec(`errors_total`, `Errors.`, `error`).Inc("say \"hi\"\\\n")
+			},
+			`# HELP errors_total Errors.
+# TYPE errors_total counter
+errors_total{error="say \"hi\"\\\n"} 1
+`,
+		},
+		{
+			`counter without series`,
+			func(r *Registry) {
+				r.NewCounterVec(`empty_total`, `Nothing.`, `label`)
+			},
+			`# HELP empty_total Nothing.
+# TYPE empty_total counter
+`,
+		},
+		{
+			`histogram buckets are cumulative`,
+			func(r *Registry) {
+				h := r.NewHistogramVec(`duration_seconds`, `Duration.`, []float64{.01, .1, 1}, `upstream`)
+				h.Observe(.005, `1.1.1.1`)
+				h.Observe(.01, `1.1.1.1`)
+				h.Observe(.5, `1.1.1.1`)
+				h.Observe(2, `1.1.1.1`)
+			},
+			`# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{upstream="1.1.1.1",le="0.01"} 2
+duration_seconds_bucket{upstream="1.1.1.1",le="0.1"} 2
+duration_seconds_bucket{upstream="1.1.1.1",le="1"} 3
+duration_seconds_bucket{upstream="1.1.1.1",le="+Inf"} 4
+duration_seconds_sum{upstream="1.1.1.1"} 2.515
+duration_seconds_count{upstream="1.1.1.1"} 4
+`,
+		},
+		{
+			`histogram without labels`,
+			func(r *Registry) {
+				r.NewHistogramVec(`lookup_seconds`, `Lookup.`, []float64{1e-05}).Observe(1e-06)
+			},
+			`# HELP lookup_seconds Lookup.
+# TYPE lookup_seconds histogram
+lookup_seconds_bucket{le="1e-05"} 1
+lookup_seconds_bucket{le="+Inf"} 1
+lookup_seconds_sum 1e-06
+lookup_seconds_count 1
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			tt.fill(r)
+
+			var buf bytes.Buffer
+
+			err := r.Write(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if buf.String() != tt.want {
+				t.Fatalf("got\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryOrder(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec(`b_total`, `B.`).Inc()
+	r.NewCounterVec(`a_total`, `A.`).Inc()
+
+	var buf bytes.Buffer
+
+	err := r.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Metrics are written in registration order
+	if strings.Index(buf.String(), `b_total`) > strings.Index(buf.String(), `a_total`) {
+		t.Fatalf("got\n%s", buf.String())
+	}
+}
+
+func TestValue(t *testing.T) {
+	c := NewRegistry().NewCounterVec(`x_total`, `X.`, `label`)
+	c.Inc(`a`)
+	c.Add(2, `a`)
+
+	if v := c.Value(`a`); v != 3 {
+		t.Fatalf(`got %v, want 3`, v)
+	}
+
+	if v := c.Value(`b`); v != 0 {
+		t.Fatalf(`got %v for unused series, want 0`, v)
+	}
+}
+
+func TestLabelCountMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal(`no panic`)
+		}
+	}()
+
+	NewRegistry().NewCounterVec(`x_total`, `X.`, `a`, `b`).Inc(`only one`)
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec(`x_total`, `X.`).Inc()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(`GET`, `/metrics`, nil))
+
+	if ct := w.Header().Get(`Content-Type`); !strings.HasPrefix(ct, `text/plain; version=0.0.4`) {
+		t.Fatalf(`got content type %q`, ct)
+	}
+
+	if !strings.Contains(w.Body.String(), "x_total 1\n") {
+		t.Fatalf("got\n%s", w.Body.String())
+	}
+}
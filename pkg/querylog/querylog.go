@@ -0,0 +1,347 @@
+package querylog
+
+/*
+Persistent query log stored as rotating JSON lines files
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileName  = `querylog.jsonl`
+	readChunk = 64 * 1024 // Size of blocks files are read backwards in
+)
+
+// Entry is a single answered DNS query
+type Entry struct {
+	ID       uint64    `json:"id"`
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Hostname string    `json:"hostname,omitempty"` // Local name of client
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Decision string    `json:"decision"`
+	Rule     string    `json:"rule,omitempty"`     // Rule that matched
+	Upstream string    `json:"upstream,omitempty"` // Forwarder used
+	Rcode    string    `json:"rcode"`
+	Latency  float64   `json:"latency"` // Milliseconds
+}
+
+// Filter limits entries returned by Log.Search. Empty fields match everything.
+type Filter struct {
+	Since    time.Time
+	Until    time.Time
+	Client   string // Address or host name
+	Name     string // Substring
+	Decision string
+	Cursor   uint64 // Return entries older than this ID, 0 for newest
+	Limit    int
+}
+
+func (f Filter) match(e Entry) bool {
+	if f.Cursor != 0 && e.ID >= f.Cursor {
+		return false
+	}
+
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+
+	if f.Client != `` && f.Client != e.Client && f.Client != e.Hostname {
+		return false
+	}
+
+	if f.Name != `` && !strings.Contains(e.Name, f.Name) {
+		return false
+	}
+
+	if f.Decision != `` && f.Decision != e.Decision {
+		return false
+	}
+
+	return true
+}
+
+type Log struct {
+	dir      string
+	maxSize  int64 // Bytes before current file is rotated
+	maxFiles int   // Rotated files kept
+	mu       sync.Mutex
+	fh       *os.File
+	w        *bufio.Writer
+	size     int64
+	lastID   uint64
+}
+
+// New opens query log in directory dir.
+// Current file is rotated when it grows over maxSize bytes and at most maxFiles old files are kept.
+func New(dir string, maxSize int64, maxFiles int) (*Log, error) {
+	if !path.IsAbs(dir) {
+		return nil, fmt.Errorf(`not absolute path: %q`, dir)
+	}
+
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:      dir,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+
+	err = l.open()
+	if err != nil {
+		return nil, err
+	}
+
+	// Continue IDs from the newest stored entry
+	err = readReverse(l.filePath(0), func(e Entry) bool {
+		l.lastID = e.ID
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	go l.flusher()
+
+	return l, nil
+}
+
+// filePath returns path of n:th file, 0 is the current file
+func (l *Log) filePath(n int) string {
+	if n == 0 {
+		return path.Join(l.dir, fileName)
+	}
+
+	return path.Join(l.dir, fmt.Sprintf(`querylog.%d.jsonl`, n))
+}
+
+func (l *Log) open() error {
+	fh, err := os.OpenFile(l.filePath(0), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+
+	fi, err := fh.Stat()
+	if err != nil {
+		_ = fh.Close()
+		return err
+	}
+
+	l.fh = fh
+	l.w = bufio.NewWriter(fh)
+	l.size = fi.Size()
+
+	return nil
+}
+
+// rotate renames querylog.jsonl to querylog.1.jsonl, querylog.1.jsonl to querylog.2.jsonl and so on
+func (l *Log) rotate() error {
+	err := l.w.Flush()
+	if err != nil {
+		return err
+	}
+
+	err = l.fh.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(l.filePath(l.maxFiles))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for i := l.maxFiles - 1; i >= 0; i-- {
+		err = os.Rename(l.filePath(i), l.filePath(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return l.open()
+}
+
+// Add appends entry to the log. Entry ID is assigned here.
+func (l *Log) Add(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// IDs are time based so that they stay increasing over restarts
+	id := uint64(e.Time.UnixNano())
+	if id <= l.lastID {
+		id = l.lastID + 1
+	}
+
+	e.ID = id
+	l.lastID = id
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	b = append(b, '\n')
+
+	if l.size+int64(len(b)) > l.maxSize && l.size > 0 {
+		err = l.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := l.w.Write(b)
+	l.size += int64(n)
+
+	return err
+}
+
+// flusher writes buffered entries to disk once a second
+func (l *Log) flusher() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for range t.C {
+		l.mu.Lock()
+		_ = l.w.Flush()
+		l.mu.Unlock()
+	}
+}
+
+// readReverse calls fn with entries of file p newest first until fn returns false.
+// File is read backwards in blocks so that only the entries needed are read.
+func readReverse(p string, fn func(e Entry) bool) error {
+	fh, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+	defer fh.Close()
+
+	fi, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+
+	off := fi.Size()
+
+	// Start of the last line of the previous block, it continues in the next block read
+	var carry []byte
+
+	for off > 0 {
+		n := int64(readChunk)
+		if off < n {
+			n = off
+		}
+
+		off -= n
+
+		b := make([]byte, n, n+int64(len(carry)))
+
+		_, err = fh.ReadAt(b, off)
+		if err != nil {
+			return err
+		}
+
+		lines := bytes.Split(append(b, carry...), []byte{'\n'})
+
+		carry = nil
+		if off > 0 {
+			carry = lines[0]
+			lines = lines[1:]
+		}
+
+		for i := len(lines) - 1; i >= 0; i-- {
+			if len(lines[i]) == 0 {
+				continue
+			}
+
+			var e Entry
+
+			err = json.Unmarshal(lines[i], &e)
+			if err != nil {
+				// Skip partially written line
+				continue
+			}
+
+			if !fn(e) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// Search returns entries matching the filter newest first.
+// next is the cursor for the next page, 0 if there are no more entries.
+func (l *Log) Search(f Filter) (entries []Entry, next uint64, err error) {
+	l.mu.Lock()
+	err = l.w.Flush()
+	l.mu.Unlock()
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+
+	// Entries are written when queries finish, so a slow query is written after faster ones started later.
+	// IDs increase in file order and are never before the query time, so entries after an ID older than
+	// Since are all older.
+	var sinceID uint64
+	if f.Since.UnixNano() > 0 {
+		sinceID = uint64(f.Since.UnixNano())
+	}
+
+	done := false
+
+	for n := 0; n <= l.maxFiles && !done; n++ {
+		err = readReverse(l.filePath(n), func(e Entry) bool {
+			if e.ID < sinceID {
+				done = true
+				return false
+			}
+
+			if !f.match(e) {
+				return true
+			}
+
+			if len(entries) == f.Limit {
+				next = entries[len(entries)-1].ID
+				done = true
+				return false
+			}
+
+			entries = append(entries, e)
+			return true
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return entries, next, nil
+}
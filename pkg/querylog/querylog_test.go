@@ -0,0 +1,223 @@
+package querylog
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fill adds n entries one second apart, entry i is named host<i>.example.com
+func fill(t *testing.T, l *Log, start time.Time, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		decision := `allowed`
+		if i%3 == 0 {
+			decision = `blocked`
+		}
+
+		err := l.Add(Entry{
+			Time:     start.Add(time.Duration(i) * time.Second),
+			Client:   fmt.Sprintf(`192.0.2.%d`, i%4),
+			Name:     fmt.Sprintf(`host%d.example.com`, i),
+			Type:     `A`,
+			Decision: decision,
+			Rcode:    `NOERROR`,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	// Entries span several files and several read blocks of each file
+	l, err := New(t.TempDir(), 3*readChunk, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	fill(t, l, start, 5000)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		first  int // Number of the newest entry, -1 if nothing matches
+		count  int
+	}{
+		{`newest`, Filter{Limit: 10}, 4999, 10},
+		{`default limit`, Filter{}, 4999, 100},
+		{`client`, Filter{Client: `192.0.2.1`, Limit: 5}, 4997, 5},
+		{`name`, Filter{Name: `host42.`, Limit: 5}, 42, 1},
+		{`decision`, Filter{Decision: `blocked`, Limit: 5}, 4998, 5},
+		{`until`, Filter{Until: start.Add(1000 * time.Second), Limit: 5}, 1000, 5},
+		{`since`, Filter{Since: start.Add(4995 * time.Second)}, 4999, 5},
+		{`nothing`, Filter{Name: `nothing`}, -1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, _, err := l.Search(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(entries) != tt.count {
+				t.Fatalf(`got %d entries, want %d`, len(entries), tt.count)
+			}
+
+			if tt.first < 0 {
+				return
+			}
+
+			want := fmt.Sprintf(`host%d.example.com`, tt.first)
+			if entries[0].Name != want {
+				t.Fatalf(`got newest %s, want %s`, entries[0].Name, want)
+			}
+		})
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	l, err := New(t.TempDir(), readChunk, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 2000
+	fill(t, l, time.Now().Add(-n*time.Second), n)
+
+	f := Filter{Limit: 150}
+	seen := 0
+	var last uint64
+
+	for {
+		entries, next, err := l.Search(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, e := range entries {
+			if last != 0 && e.ID >= last {
+				t.Fatalf(`entry %d not older than %d`, e.ID, last)
+			}
+
+			last = e.ID
+			seen++
+		}
+
+		if next == 0 {
+			break
+		}
+
+		f.Cursor = next
+	}
+
+	if seen != n {
+		t.Fatalf(`paged through %d entries, want %d`, seen, n)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := New(dir, 1<<20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries in the future so that reopening can't assign a larger ID from the clock
+	fill(t, l, time.Now().Add(time.Hour), 3)
+
+	entries, _, err := l.Search(Filter{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Partially written line at the end is skipped
+	fh, err := os.OpenFile(l.filePath(0), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fh.WriteString(`{"id":`)
+	fh.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l2, err := New(dir, 1<<20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l2.lastID != entries[0].ID {
+		t.Fatalf(`got last ID %d, want %d`, l2.lastID, entries[0].ID)
+	}
+
+	got, _, err := l2.Search(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 3 || !strings.HasPrefix(got[0].Name, `host2.`) {
+		t.Fatalf(`got %d entries after reopen`, len(got))
+	}
+}
+
+func TestSearchOutOfOrder(t *testing.T) {
+	l, err := New(t.TempDir(), 1<<20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Queries are written when they finish, a slow query is written after faster ones which started later
+	for _, e := range []struct {
+		name string
+		at   time.Duration
+	}{
+		{`early`, 0},
+		{`fast1`, 20 * time.Second},
+		{`fast2`, 25 * time.Second},
+		{`slow`, 10 * time.Second},
+		{`slower`, 5 * time.Second},
+	} {
+		err = l.Add(Entry{Time: start.Add(e.at), Name: e.name, Type: `A`, Decision: `allowed`})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		since time.Duration
+		want  []string
+	}{
+		{15 * time.Second, []string{`fast2`, `fast1`}},
+		{10 * time.Second, []string{`slow`, `fast2`, `fast1`}},
+		{time.Second, []string{`slower`, `slow`, `fast2`, `fast1`}},
+		{time.Minute, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.since.String(), func(t *testing.T) {
+			entries, _, err := l.Search(Filter{Since: start.Add(tt.since)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Name)
+			}
+
+			if strings.Join(got, ` `) != strings.Join(tt.want, ` `) {
+				t.Fatalf(`got %q, want %q`, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,525 @@
+package rpz
+
+/*
+Response policy zones (RPZ) which are DNS zones carrying resolver policy, see
+https://datatracker.ietf.org/doc/html/draft-vixie-dnsop-dns-rpz
+
+Supported triggers are QNAME, response IP (rpz-ip) and NSDNAME (rpz-nsdname).
+Client IP and NSIP triggers are ignored.
+*/
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Triggers
+const (
+	TriggerQName   = `qname`
+	TriggerIP      = `ip`
+	TriggerNSDName = `nsdname`
+)
+
+// Actions
+const (
+	ActionNXDomain = `nxdomain` // CNAME .
+	ActionNoData   = `nodata`   // CNAME *.
+	ActionPassthru = `passthru` // CNAME rpz-passthru.
+	ActionDrop     = `drop`     // CNAME rpz-drop.
+	ActionLocal    = `local`    // Any other records are answered instead
+)
+
+// Timeouts of queries and zone transfers from primary servers
+const (
+	queryTimeout    = 5 * time.Second
+	transferTimeout = 30 * time.Second // Read timeout of each message of a transfer
+)
+
+// Policy is a single trigger and its action
+type Policy struct {
+	Zone    string   // Policy zone name
+	Trigger string   // TriggerQName, TriggerIP or TriggerNSDName
+	Match   string   // Name or network that triggered
+	Action  string   // One of the actions
+	Records []dns.RR // Local data of ActionLocal, owner names are replaced with the question name
+}
+
+func (p Policy) String() string {
+	return fmt.Sprintf(`%s %s %s %s`, p.Zone, p.Trigger, p.Match, p.Action)
+}
+
+// Answer is the local data of policy p for question q. Empty for NODATA.
+func (p Policy) Answer(q dns.Question) (rrs []dns.RR) {
+	for _, rr := range p.Records {
+		t := rr.Header().Rrtype
+
+		if t != q.Qtype && t != dns.TypeCNAME {
+			continue
+		}
+
+		rr = dns.Copy(rr)
+		rr.Header().Name = q.Name
+		rrs = append(rrs, rr)
+	}
+
+	return rrs
+}
+
+type ipPolicy struct {
+	net    *net.IPNet
+	policy Policy
+}
+
+// Zone is a parsed policy zone
+type Zone struct {
+	Name      string // Zone name with trailing dot
+	Serial    uint32 // SOA serial, zero if the zone has no SOA
+	qnames    map[string]Policy
+	wildcards map[string]Policy // Parent name -> policy of *.parent
+	nsdnames  map[string]Policy
+	nswild    map[string]Policy
+	ips       []ipPolicy // Longest prefix first
+}
+
+// New builds zone name from its records
+func New(name string, rrs []dns.RR) (*Zone, error) {
+	name = dns.Fqdn(strings.ToLower(name))
+
+	z := &Zone{
+		Name:      name,
+		qnames:    make(map[string]Policy),
+		wildcards: make(map[string]Policy),
+		nsdnames:  make(map[string]Policy),
+		nswild:    make(map[string]Policy),
+	}
+
+	// Records of an owner together decide its action
+	owners := make(map[string][]dns.RR)
+	var order []string
+
+	for _, rr := range rrs {
+		owner := strings.ToLower(rr.Header().Name)
+
+		if owner == name {
+			if soa, ok := rr.(*dns.SOA); ok {
+				z.Serial = soa.Serial
+			}
+
+			// Apex SOA and NS
+			continue
+		}
+
+		if !dns.IsSubDomain(name, owner) {
+			return nil, fmt.Errorf(`rpz %s: record %s outside of zone`, name, owner)
+		}
+
+		if _, ok := owners[owner]; !ok {
+			order = append(order, owner)
+		}
+
+		owners[owner] = append(owners[owner], rr)
+	}
+
+	for _, owner := range order {
+		err := z.add(strings.TrimSuffix(owner, `.`+name), owners[owner])
+		if err != nil {
+			return nil, fmt.Errorf(`rpz %s: %s: %w`, name, owner, err)
+		}
+	}
+
+	sort.SliceStable(z.ips, func(i, j int) bool {
+		a, _ := z.ips[i].net.Mask.Size()
+		b, _ := z.ips[j].net.Mask.Size()
+		return a > b
+	})
+
+	return z, nil
+}
+
+// add adds trigger of owner name rel relative to the zone
+func (z *Zone) add(rel string, rrs []dns.RR) error {
+	action := ActionLocal
+
+	if len(rrs) == 1 {
+		if cname, ok := rrs[0].(*dns.CNAME); ok {
+			switch strings.ToLower(cname.Target) {
+			case `.`:
+				action = ActionNXDomain
+			case `*.`:
+				action = ActionNoData
+			case `rpz-passthru.`:
+				action = ActionPassthru
+			case `rpz-drop.`:
+				action = ActionDrop
+			case `rpz-tcp-only.`:
+				// Not supported, every answer is passed as is
+				action = ActionPassthru
+			}
+		}
+	}
+
+	p := Policy{
+		Zone:   strings.TrimSuffix(z.Name, `.`),
+		Action: action,
+	}
+
+	if action == ActionLocal {
+		p.Records = rrs
+	}
+
+	labels := dns.SplitDomainName(rel)
+	last := labels[len(labels)-1]
+
+	switch last {
+	case `rpz-ip`:
+		ipnet, err := parseIPTrigger(labels[:len(labels)-1])
+		if err != nil {
+			return err
+		}
+
+		p.Trigger = TriggerIP
+		p.Match = ipnet.String()
+		z.ips = append(z.ips, ipPolicy{net: ipnet, policy: p})
+
+	case `rpz-nsdname`:
+		p.Trigger = TriggerNSDName
+		addName(z.nsdnames, z.nswild, strings.Join(labels[:len(labels)-1], `.`), p)
+
+	case `rpz-client-ip`, `rpz-nsip`:
+		// Not supported
+
+	default:
+		p.Trigger = TriggerQName
+		addName(z.qnames, z.wildcards, rel, p)
+	}
+
+	return nil
+}
+
+// addName adds name trigger to exact names or wildcards if it starts with *.
+func addName(exact map[string]Policy, wildcards map[string]Policy, name string, p Policy) {
+	p.Match = name
+
+	if strings.HasPrefix(name, `*.`) {
+		wildcards[dns.Fqdn(name[2:])] = p
+		return
+	}
+
+	exact[dns.Fqdn(name)] = p
+}
+
+// parseIPTrigger parses reversed network of rpz-ip trigger, for example 24.0.2.0.192 or 48.zz.db8.2001
+func parseIPTrigger(labels []string) (*net.IPNet, error) {
+	if len(labels) < 2 {
+		return nil, fmt.Errorf(`invalid IP trigger`)
+	}
+
+	prefix, err := strconv.Atoi(labels[0])
+	if err != nil {
+		return nil, fmt.Errorf(`invalid IP trigger prefix %q`, labels[0])
+	}
+
+	rev := make([]string, 0, len(labels)-1)
+	for i := len(labels) - 1; i > 0; i-- {
+		rev = append(rev, labels[i])
+	}
+
+	var addr string
+
+	// IPv4 triggers have exactly four decimal labels, IPv6 triggers eight hexadecimal labels or zz for zeros
+	if ipv4Labels(rev) {
+		if prefix < 1 || prefix > 32 {
+			return nil, fmt.Errorf(`invalid IPv4 trigger prefix %d`, prefix)
+		}
+
+		addr = strings.Join(rev, `.`)
+	} else {
+		if prefix < 1 || prefix > 128 {
+			return nil, fmt.Errorf(`invalid IPv6 trigger prefix %d`, prefix)
+		}
+
+		zeros := 0
+
+		for i, l := range rev {
+			if l == `zz` {
+				rev[i] = ``
+				zeros++
+			}
+		}
+
+		if zeros > 1 || (zeros == 0 && len(rev) != 8) {
+			return nil, fmt.Errorf(`invalid IPv6 trigger`)
+		}
+
+		addr = strings.Join(rev, `:`)
+
+		if strings.HasPrefix(addr, `:`) {
+			addr = `:` + addr
+		}
+
+		if strings.HasSuffix(addr, `:`) {
+			addr += `:`
+		}
+	}
+
+	_, ipnet, err := net.ParseCIDR(addr + `/` + strconv.Itoa(prefix))
+	if err != nil {
+		return nil, fmt.Errorf(`invalid IP trigger: %w`, err)
+	}
+
+	return ipnet, nil
+}
+
+// ipv4Labels tells if address labels of an IP trigger are an IPv4 address
+func ipv4Labels(labels []string) bool {
+	if len(labels) != 4 {
+		return false
+	}
+
+	for _, l := range labels {
+		if l == `` || strings.Trim(l, `0123456789`) != `` {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lookupName finds the exact trigger of name or the closest wildcard
+func lookupName(exact map[string]Policy, wildcards map[string]Policy, name string) (Policy, bool) {
+	name = dns.Fqdn(strings.ToLower(name))
+
+	if p, ok := exact[name]; ok {
+		return p, true
+	}
+
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		if p, ok := wildcards[name[off:]]; ok {
+			return p, true
+		}
+	}
+
+	return Policy{}, false
+}
+
+// QName finds policy triggered by query or CNAME target name
+func (z *Zone) QName(name string) (Policy, bool) {
+	return lookupName(z.qnames, z.wildcards, name)
+}
+
+// NSDName finds policy triggered by name server name
+func (z *Zone) NSDName(name string) (Policy, bool) {
+	return lookupName(z.nsdnames, z.nswild, name)
+}
+
+// IP finds policy of the most specific network containing answer address ip
+func (z *Zone) IP(ip net.IP) (Policy, bool) {
+	for _, p := range z.ips {
+		if p.net.Contains(ip) {
+			return p.policy, true
+		}
+	}
+
+	return Policy{}, false
+}
+
+// Parse reads policy zone name in zone file format from r
+func Parse(r io.Reader, name string, file string) (*Zone, error) {
+	var rrs []dns.RR
+
+	zp := dns.NewZoneParser(r, dns.Fqdn(name), file)
+
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+
+	return New(name, rrs)
+}
+
+// ReadFile reads policy zone name from zone file p
+func ReadFile(p string, name string) (*Zone, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f, name, p)
+}
+
+// Serial queries the SOA serial of zone name from primary
+func Serial(primary string, name string) (uint32, error) {
+	m := &dns.Msg{}
+	m.SetQuestion(dns.Fqdn(name), dns.TypeSOA)
+
+	c := &dns.Client{Timeout: queryTimeout}
+
+	reply, _, err := c.Exchange(m, primary)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, rr := range reply.Answer {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial, nil
+		}
+	}
+
+	return 0, fmt.Errorf(`rpz %s: no SOA from %s`, name, primary)
+}
+
+// Transfer gets policy zone name from primary with AXFR
+func Transfer(primary string, name string) (*Zone, error) {
+	m := &dns.Msg{}
+	m.SetAxfr(dns.Fqdn(name))
+
+	t := &dns.Transfer{
+		DialTimeout:  queryTimeout,
+		ReadTimeout:  transferTimeout,
+		WriteTimeout: queryTimeout,
+	}
+
+	ch, err := t.In(m, primary)
+	if err != nil {
+		return nil, err
+	}
+
+	var rrs []dns.RR
+
+	for env := range ch {
+		if env.Error != nil {
+			return nil, env.Error
+		}
+
+		rrs = append(rrs, env.RR...)
+	}
+
+	return New(name, rrs)
+}
+
+// Source is where a policy zone is loaded from, either File or Primary
+type Source struct {
+	Name    string // Zone name
+	File    string // Zone file
+	Primary string // Address of primary server for AXFR, host:port
+}
+
+// Watch loads policy zone from src every interval and calls fn when it changed.
+// Files are read again when their modification time changes, zones are transferred again when the SOA serial changes.
+func Watch(src Source, interval time.Duration, fn func(*Zone), errch chan error) {
+	var modified time.Time
+	var serial uint32
+	loaded := false
+	failing := false
+
+	for {
+		var z *Zone
+		var err error
+
+		if src.File != `` {
+			var fi os.FileInfo
+
+			fi, err = os.Stat(src.File)
+			if err == nil && !fi.ModTime().Equal(modified) {
+				z, err = ReadFile(src.File, src.Name)
+				if err == nil {
+					modified = fi.ModTime()
+				}
+			}
+		} else {
+			var s uint32
+
+			s, err = Serial(src.Primary, src.Name)
+			if err == nil && (!loaded || s != serial) {
+				z, err = Transfer(src.Primary, src.Name)
+				if err == nil {
+					serial = z.Serial
+				}
+			}
+		}
+
+		if z != nil {
+			loaded = true
+			fn(z)
+		}
+
+		if err != nil && !failing {
+			errch <- err
+		}
+
+		failing = err != nil
+
+		time.Sleep(interval)
+	}
+}
+
+// Set is an ordered list of policy zones. The first zone with a matching trigger decides.
+type Set struct {
+	mu    sync.RWMutex
+	zones []*Zone // nil until loaded
+}
+
+// NewSet makes a set of n zones
+func NewSet(n int) *Set {
+	return &Set{
+		zones: make([]*Zone, n),
+	}
+}
+
+// Update replaces zone i
+func (s *Set) Update(i int, z *Zone) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.zones[i] = z
+}
+
+func (s *Set) find(fn func(z *Zone) (Policy, bool)) (Policy, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, z := range s.zones {
+		if z == nil {
+			continue
+		}
+
+		if p, ok := fn(z); ok {
+			return p, true
+		}
+	}
+
+	return Policy{}, false
+}
+
+// QName finds policy triggered by query or CNAME target name
+func (s *Set) QName(name string) (Policy, bool) {
+	return s.find(func(z *Zone) (Policy, bool) {
+		return z.QName(name)
+	})
+}
+
+// NSDName finds policy triggered by name server name
+func (s *Set) NSDName(name string) (Policy, bool) {
+	return s.find(func(z *Zone) (Policy, bool) {
+		return z.NSDName(name)
+	})
+}
+
+// IP finds policy triggered by answer address ip
+func (s *Set) IP(ip net.IP) (Policy, bool) {
+	return s.find(func(z *Zone) (Policy, bool) {
+		return z.IP(ip)
+	})
+}
@@ -0,0 +1,402 @@
+package rpz
+
+import (
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testZone = `$TTL 300
+@ SOA ns.rpz.example. admin.rpz.example. 42 3600 600 86400 300
+@ NS ns.rpz.example.
+blocked.example.com CNAME .
+nodata.example.com CNAME *.
+pass.example.com CNAME rpz-passthru.
+drop.example.com CNAME rpz-drop.
+tcp.example.com CNAME rpz-tcp-only.
+*.ads.example.com CNAME .
+walled.example.com A 192.0.2.80
+walled.example.com AAAA 2001:db8::80
+garden.example.com CNAME walled.example.net.
+24.0.2.0.192.rpz-ip CNAME .
+32.1.2.0.192.rpz-ip CNAME rpz-passthru.
+48.zz.db8.2001.rpz-ip CNAME *.
+ns.evil.example.rpz-nsdname CNAME .
+*.bad.example.rpz-nsdname CNAME rpz-drop.
+24.0.2.0.192.rpz-client-ip CNAME .
+`
+
+func parseTestZone(t *testing.T) *Zone {
+	t.Helper()
+
+	z, err := Parse(strings.NewReader(testZone), `rpz.example`, `test`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return z
+}
+
+func TestParse(t *testing.T) {
+	z := parseTestZone(t)
+
+	if z.Name != `rpz.example.` {
+		t.Errorf(`got zone name %q`, z.Name)
+	}
+
+	if z.Serial != 42 {
+		t.Errorf(`got serial %d, want 42`, z.Serial)
+	}
+}
+
+func TestQName(t *testing.T) {
+	z := parseTestZone(t)
+
+	tests := []struct {
+		name    string
+		action  string // Empty if no trigger
+		match   string
+		records int
+	}{
+		{`blocked.example.com.`, ActionNXDomain, `blocked.example.com`, 0},
+		{`BLOCKED.Example.COM`, ActionNXDomain, `blocked.example.com`, 0},
+		{`www.blocked.example.com.`, ``, ``, 0},
+		{`nodata.example.com.`, ActionNoData, `nodata.example.com`, 0},
+		{`pass.example.com.`, ActionPassthru, `pass.example.com`, 0},
+		{`drop.example.com.`, ActionDrop, `drop.example.com`, 0},
+		{`tcp.example.com.`, ActionPassthru, `tcp.example.com`, 0},
+		{`track.ads.example.com.`, ActionNXDomain, `*.ads.example.com`, 0},
+		{`a.b.ads.example.com.`, ActionNXDomain, `*.ads.example.com`, 0},
+		{`ads.example.com.`, ``, ``, 0},
+		{`walled.example.com.`, ActionLocal, `walled.example.com`, 2},
+		{`garden.example.com.`, ActionLocal, `garden.example.com`, 1},
+		{`example.com.`, ``, ``, 0},
+		{`ns.evil.example.`, ``, ``, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := z.QName(tt.name)
+
+			if tt.action == `` {
+				if ok {
+					t.Fatalf(`unexpected policy %s`, p)
+				}
+
+				return
+			}
+
+			if !ok {
+				t.Fatal(`no policy`)
+			}
+
+			if p.Trigger != TriggerQName || p.Action != tt.action || p.Match != tt.match || p.Zone != `rpz.example` {
+				t.Fatalf(`got policy %s`, p)
+			}
+
+			if len(p.Records) != tt.records {
+				t.Fatalf(`got %d records, want %d`, len(p.Records), tt.records)
+			}
+		})
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	z := parseTestZone(t)
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  []string // Answer records
+	}{
+		{`walled.example.com.`, dns.TypeA, []string{`www.example.com.	300	IN	A	192.0.2.80`}},
+		{`walled.example.com.`, dns.TypeAAAA, []string{`www.example.com.	300	IN	AAAA	2001:db8::80`}},
+		{`walled.example.com.`, dns.TypeMX, nil},
+		{`garden.example.com.`, dns.TypeA, []string{`www.example.com.	300	IN	CNAME	walled.example.net.`}},
+		{`garden.example.com.`, dns.TypeTXT, []string{`www.example.com.	300	IN	CNAME	walled.example.net.`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+` `+dns.Type(tt.qtype).String(), func(t *testing.T) {
+			p, ok := z.QName(tt.name)
+			if !ok {
+				t.Fatal(`no policy`)
+			}
+
+			rrs := p.Answer(dns.Question{Name: `www.example.com.`, Qtype: tt.qtype, Qclass: dns.ClassINET})
+
+			if len(rrs) != len(tt.want) {
+				t.Fatalf(`got %v, want %v`, rrs, tt.want)
+			}
+
+			for i, rr := range rrs {
+				if rr.String() != tt.want[i] {
+					t.Fatalf(`got %q, want %q`, rr.String(), tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIP(t *testing.T) {
+	z := parseTestZone(t)
+
+	tests := []struct {
+		ip     string
+		action string // Empty if no trigger
+		match  string
+	}{
+		{`192.0.2.10`, ActionNXDomain, `192.0.2.0/24`},
+		{`192.0.2.1`, ActionPassthru, `192.0.2.1/32`},
+		{`192.0.3.1`, ``, ``},
+		{`2001:db8::1`, ActionNoData, `2001:db8::/48`},
+		{`2001:db8:1::1`, ``, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			p, ok := z.IP(net.ParseIP(tt.ip))
+
+			if tt.action == `` {
+				if ok {
+					t.Fatalf(`unexpected policy %s`, p)
+				}
+
+				return
+			}
+
+			if !ok {
+				t.Fatal(`no policy`)
+			}
+
+			if p.Trigger != TriggerIP || p.Action != tt.action || p.Match != tt.match {
+				t.Fatalf(`got policy %s`, p)
+			}
+		})
+	}
+}
+
+func TestNSDName(t *testing.T) {
+	z := parseTestZone(t)
+
+	tests := []struct {
+		name   string
+		action string // Empty if no trigger
+	}{
+		{`ns.evil.example.`, ActionNXDomain},
+		{`NS.Evil.Example`, ActionNXDomain},
+		{`ns1.bad.example.`, ActionDrop},
+		{`bad.example.`, ``},
+		{`blocked.example.com.`, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := z.NSDName(tt.name)
+
+			if tt.action == `` {
+				if ok {
+					t.Fatalf(`unexpected policy %s`, p)
+				}
+
+				return
+			}
+
+			if !ok || p.Trigger != TriggerNSDName || p.Action != tt.action {
+				t.Fatalf(`got policy %s %v`, p, ok)
+			}
+		})
+	}
+}
+
+func TestParseIPTrigger(t *testing.T) {
+	tests := []struct {
+		trigger string
+		want    string // Empty if invalid
+	}{
+		{`32.1.2.0.192`, `192.0.2.1/32`},
+		{`24.0.2.0.192`, `192.0.2.0/24`},
+		{`8.0.0.0.10`, `10.0.0.0/8`},
+		{`128.1.zz.db8.2001`, `2001:db8::1/128`},
+		{`48.zz.db8.2001`, `2001:db8::/48`},
+		{`64.0.0.0.0.0.0.db8.2001`, `2001:db8::/64`},
+		{`64.0.0.0.0.0.db8.2001`, ``},
+		{`32.zz.db8.2001`, `2001:db8::/32`},
+		{`32.1.zz.2001`, `2001::/32`},
+		{`128.1.2.3.zz`, `::3:2:1/128`},
+		{`32.1.2.3`, ``},
+		{`32.zz.1.zz.2001`, ``},
+		{`0.0.2.0.192`, ``},
+		{`129.1.zz.2001`, ``},
+		{`24`, ``},
+		{`x.0.2.0.192`, ``},
+		{`33.1.2.0.192`, ``},
+		{`24.0.2.300.192`, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.trigger, func(t *testing.T) {
+			ipnet, err := parseIPTrigger(strings.Split(tt.trigger, `.`))
+
+			if tt.want == `` {
+				if err == nil {
+					t.Fatalf(`got %s, want error`, ipnet)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ipnet.String() != tt.want {
+				t.Fatalf(`got %s, want %s`, ipnet, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewOutOfZone(t *testing.T) {
+	rr, err := dns.NewRR(`blocked.example.com. 300 IN CNAME .`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(`rpz.example`, []dns.RR{rr}); err == nil {
+		t.Fatal(`record outside of zone was accepted`)
+	}
+}
+
+// serveZone starts a DNS server answering SOA queries and AXFR of the test zone
+func serveZone(t *testing.T) string {
+	t.Helper()
+
+	var rrs []dns.RR
+
+	zp := dns.NewZoneParser(strings.NewReader(testZone), `rpz.example.`, `test`)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+
+	if err := zp.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	soa := rrs[0]
+
+	mux := dns.NewServeMux()
+	mux.HandleFunc(`rpz.example.`, func(w dns.ResponseWriter, req *dns.Msg) {
+		if req.Question[0].Qtype == dns.TypeAXFR {
+			ch := make(chan *dns.Envelope)
+			tr := &dns.Transfer{}
+
+			go func() {
+				// AXFR starts and ends with the SOA
+				ch <- &dns.Envelope{RR: rrs[:5]}
+				ch <- &dns.Envelope{RR: append(rrs[5:], soa)}
+				close(ch)
+			}()
+
+			if err := tr.Out(w, req, ch); err != nil {
+				t.Error(err)
+			}
+
+			w.Hijack()
+			return
+		}
+
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		resp.Authoritative = true
+
+		if req.Question[0].Qtype == dns.TypeSOA {
+			resp.Answer = append(resp.Answer, soa)
+		}
+
+		if err := w.WriteMsg(resp); err != nil {
+			t.Error(err)
+		}
+	})
+
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := net.ListenPacket(`udp`, l.Addr().String())
+	if err != nil {
+		l.Close()
+		t.Fatal(err)
+	}
+
+	tcp := &dns.Server{Listener: l, Handler: mux}
+	udp := &dns.Server{PacketConn: pc, Handler: mux}
+
+	for _, srv := range []*dns.Server{tcp, udp} {
+		srv := srv
+
+		go func() {
+			if err := srv.ActivateAndServe(); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		t.Cleanup(func() {
+			srv.Shutdown()
+		})
+	}
+
+	return l.Addr().String()
+}
+
+func TestTransfer(t *testing.T) {
+	addr := serveZone(t)
+
+	serial, err := Serial(addr, `rpz.example`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if serial != 42 {
+		t.Fatalf(`got serial %d, want 42`, serial)
+	}
+
+	z, err := Transfer(addr, `rpz.example`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if z.Serial != 42 {
+		t.Fatalf(`got serial %d of transferred zone, want 42`, z.Serial)
+	}
+
+	if p, ok := z.QName(`blocked.example.com.`); !ok || p.Action != ActionNXDomain {
+		t.Fatalf(`got policy %s %v`, p, ok)
+	}
+
+	if p, ok := z.IP(net.ParseIP(`192.0.2.10`)); !ok || p.Action != ActionNXDomain {
+		t.Fatalf(`got policy %s %v`, p, ok)
+	}
+}
+
+func TestTransferTimeout(t *testing.T) {
+	// Nothing answers, so the query must time out instead of hanging
+	pc, err := net.ListenPacket(`udp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	start := time.Now()
+
+	if _, err := Serial(pc.LocalAddr().String(), `rpz.example`); err == nil {
+		t.Fatal(`no error without a reply`)
+	}
+
+	if d := time.Since(start); d > 2*queryTimeout {
+		t.Fatalf(`query took %v`, d)
+	}
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+)
+
+// ACL actions for clients which aren't allowed
+const (
+	aclRefused = `refused` // Reply with REFUSED
+	aclDrop    = `drop`    // No reply
+)
+
+// ACL is the access control list of DNS listeners. Denied networks win over allowed networks.
+type ACL struct {
+	Listen []string `json:"listen,omitempty"` // Listen addresses the list applies to, empty for listeners without a list of their own
+	Allow  []string `json:"allow"`            // CIDR or single address
+	Deny   []string `json:"deny,omitempty"`   // CIDR or single address
+	Action string   `json:"action"`           // refused (default) or drop
+}
+
+// defaultACLNets are the clients allowed on listeners without an access control list, so that
+// a listener on a public address never becomes an open resolver: private, loopback and link-local addresses
+var defaultACLNets = append(mustParseCIDRs(`127.0.0.0/8`, `::1/128`, `fe80::/10`), privateNets...)
+
+type acl struct {
+	allow  []*net.IPNet
+	deny   []*net.IPNet
+	action string
+}
+
+var defaultACL = &acl{
+	allow:  defaultACLNets,
+	action: aclRefused,
+}
+
+// parseNets parses CIDRs and single addresses
+func parseNets(l []string) (nets []*net.IPNet, err error) {
+	for _, n := range l {
+		if !strings.Contains(n, `/`) {
+			if ip := net.ParseIP(n); ip != nil && ip.To4() != nil {
+				n += `/32`
+			} else {
+				n += `/128`
+			}
+		}
+
+		_, ipnet, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, err
+		}
+
+		nets = append(nets, ipnet)
+	}
+
+	return nets, nil
+}
+
+func newACL(cfg ACL) (a *acl, err error) {
+	a = &acl{action: cfg.Action}
+
+	switch a.action {
+	case ``:
+		a.action = aclRefused
+	case aclRefused, aclDrop:
+	default:
+		return nil, fmt.Errorf(`acl: unknown action %q`, cfg.Action)
+	}
+
+	a.allow, err = parseNets(cfg.Allow)
+	if err != nil {
+		return nil, fmt.Errorf(`acl: %w`, err)
+	}
+
+	a.deny, err = parseNets(cfg.Deny)
+	if err != nil {
+		return nil, fmt.Errorf(`acl: %w`, err)
+	}
+
+	return a, nil
+}
+
+// listenerACLs gets the access control list of each listen address.
+// A list naming the address wins over a list without addresses which wins over the default.
+func listenerACLs(listen []string, cfg []ACL) (map[string]*acl, error) {
+	byAddr := make(map[string]*acl)
+	fallback := defaultACL
+
+	for _, c := range cfg {
+		a, err := newACL(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(c.Listen) == 0 {
+			fallback = a
+			continue
+		}
+
+		for _, l := range c.Listen {
+			byAddr[l] = a
+		}
+	}
+
+	acls := make(map[string]*acl)
+
+	for _, l := range listen {
+		a, ok := byAddr[l]
+		if !ok {
+			a = fallback
+		}
+
+		acls[l] = a
+	}
+
+	for l := range byAddr {
+		if _, ok := acls[l]; !ok {
+			return nil, fmt.Errorf(`acl: %q is not a listen address`, l)
+		}
+	}
+
+	return acls, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// permit tells if client ip may query
+func (a *acl) permit(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	if contains(a.deny, ip) {
+		return false
+	}
+
+	return contains(a.allow, ip)
+}
+
+// denied checks client of the query against access control list a. Denied queries are refused or dropped.
+func (s *Service) denied(w dns.ResponseWriter, req *dns.Msg, q *query, a *acl) bool {
+	if a.permit(q.client) {
+		return false
+	}
+
+	q.decision = decisionDenied
+
+	if a.action == aclDrop {
+		return true
+	}
+
+	resp := &dns.Msg{}
+	resp.SetRcode(req, dns.RcodeRefused)
+	q.rcode = resp.Rcode
+
+	err := w.WriteMsg(resp)
+	if err != nil {
+		s.errch <- err
+	}
+
+	return true
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenerACLs(t *testing.T) {
+	listen := []string{`127.0.0.1:53`, `192.168.1.1:53`, `[::1]:53`}
+
+	named := ACL{Listen: []string{`192.168.1.1:53`}, Allow: []string{`192.168.1.0/24`}, Action: aclDrop}
+	unnamed := ACL{Allow: []string{`10.0.0.0/8`}}
+
+	tests := []struct {
+		name string
+		cfg  []ACL
+		// Client allowed on each listen address
+		allowed map[string]string
+		action  map[string]string
+	}{
+		{
+			`default`,
+			nil,
+			map[string]string{`127.0.0.1:53`: `192.168.5.5`, `192.168.1.1:53`: `10.1.1.1`, `[::1]:53`: `fd00::1`},
+			map[string]string{`127.0.0.1:53`: aclRefused, `192.168.1.1:53`: aclRefused, `[::1]:53`: aclRefused},
+		},
+		{
+			`unnamed beats default`,
+			[]ACL{unnamed},
+			map[string]string{`127.0.0.1:53`: `10.1.1.1`, `192.168.1.1:53`: `10.1.1.1`, `[::1]:53`: `10.1.1.1`},
+			map[string]string{`127.0.0.1:53`: aclRefused, `192.168.1.1:53`: aclRefused, `[::1]:53`: aclRefused},
+		},
+		{
+			`named beats unnamed`,
+			[]ACL{named, unnamed},
+			map[string]string{`127.0.0.1:53`: `10.1.1.1`, `192.168.1.1:53`: `192.168.1.100`, `[::1]:53`: `10.1.1.1`},
+			map[string]string{`127.0.0.1:53`: aclRefused, `192.168.1.1:53`: aclDrop, `[::1]:53`: aclRefused},
+		},
+		{
+			`named beats default`,
+			[]ACL{named},
+			map[string]string{`127.0.0.1:53`: `192.168.5.5`, `192.168.1.1:53`: `192.168.1.100`, `[::1]:53`: `fd00::1`},
+			map[string]string{`127.0.0.1:53`: aclRefused, `192.168.1.1:53`: aclDrop, `[::1]:53`: aclRefused},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acls, err := listenerACLs(listen, tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(acls) != len(listen) {
+				t.Fatalf(`got %d lists, want %d`, len(acls), len(listen))
+			}
+
+			for l, client := range tt.allowed {
+				a := acls[l]
+
+				if !a.permit(net.ParseIP(client)) {
+					t.Fatalf(`%s: %s not allowed`, l, client)
+				}
+
+				if a.action != tt.action[l] {
+					t.Fatalf(`%s: got action %q, want %q`, l, a.action, tt.action[l])
+				}
+			}
+		})
+	}
+}
+
+func TestListenerACLsInvalid(t *testing.T) {
+	listen := []string{`127.0.0.1:53`}
+
+	tests := []struct {
+		name string
+		cfg  []ACL
+	}{
+		{`not a listen address`, []ACL{{Listen: []string{`192.168.1.1:53`}, Allow: []string{`192.168.1.0/24`}}}},
+		{`unknown action`, []ACL{{Allow: []string{`192.168.1.0/24`}, Action: `reject`}}},
+		{`invalid allow`, []ACL{{Allow: []string{`192.168.1.0/33`}}}},
+		{`invalid deny`, []ACL{{Allow: []string{`192.168.1.0/24`}, Deny: []string{`bogus`}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := listenerACLs(listen, tt.cfg); err == nil {
+				t.Fatal(`no error`)
+			}
+		})
+	}
+}
+
+func TestACLPermit(t *testing.T) {
+	a, err := newACL(ACL{
+		Allow: []string{`192.168.1.0/24`, `fd00::/8`, `10.0.0.1`},
+		Deny:  []string{`192.168.1.13`, `fd00::bad`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{`192.168.1.1`, true},
+		{`192.168.1.13`, false},
+		{`192.168.2.1`, false},
+		{`10.0.0.1`, true},
+		{`10.0.0.2`, false},
+		{`fd00::1`, true},
+		{`fd00::bad`, false},
+		{`2001:db8::1`, false},
+		{`::ffff:192.168.1.1`, true},
+		{``, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := a.permit(net.ParseIP(tt.ip)); got != tt.want {
+				t.Fatalf(`got %v, want %v`, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNets(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`192.168.1.1`, `192.168.1.1/32`},
+		{`fd00::1`, `fd00::1/128`},
+		{`192.168.1.0/24`, `192.168.1.0/24`},
+		{`192.168.1.1/24`, `192.168.1.0/24`},
+		{`fd00::/8`, `fd00::/8`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			nets, err := parseNets([]string{tt.in})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := nets[0].String(); got != tt.want {
+				t.Fatalf(`got %s, want %s`, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package service
 
-import "strings"
+import (
+	"net"
+	"strings"
+)
 
 type arpatype uint8
 
@@ -9,14 +12,16 @@ const (
 	arpaipv6
 )
 
+// arpaPTRToString gets the address of reverse lookup name q
 func arpaPTRToString(q string) string {
 	t := arpaipv4
+	q = strings.TrimSuffix(strings.ToLower(q), `.`)
 
 	if strings.Contains(q, `in-addr.arpa`) {
-		q = strings.TrimRight(q, `.in-addr.arpa`)
+		q = strings.TrimSuffix(q, `.in-addr.arpa`)
 	} else {
 		t = arpaipv6
-		q = strings.TrimRight(q, `.ip6.arpa`)
+		q = strings.TrimSuffix(q, `.ip6.arpa`)
 	}
 
 	ip := strings.Split(q, `.`)
@@ -26,7 +31,18 @@ func arpaPTRToString(q string) string {
 
 	if t == arpaipv4 {
 		return strings.Join(ip, `.`)
-	} else {
+	}
+
+	// Nibbles are grouped by four to an address that rules of it are stored by
+	var groups []string
+	for i := 0; i+4 <= len(ip); i += 4 {
+		groups = append(groups, strings.Join(ip[i:i+4], ``))
+	}
+
+	addr := net.ParseIP(strings.Join(groups, `:`))
+	if addr == nil {
 		return strings.Join(ip, `:`)
 	}
+
+	return addr.String()
 }
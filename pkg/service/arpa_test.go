@@ -0,0 +1,23 @@
+package service
+
+import "testing"
+
+func TestArpaPTRToString(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{`1.2.0.192.in-addr.arpa`, `192.0.2.1`},
+		{`1.2.0.192.IN-ADDR.ARPA.`, `192.0.2.1`},
+		{`1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.`, `2001:db8::1`},
+		{`a.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.6.ip6.arpa`, `6000::a`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := arpaPTRToString(tt.name); got != tt.want {
+				t.Fatalf(`got %q, want %q`, got, tt.want)
+			}
+		})
+	}
+}
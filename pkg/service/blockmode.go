@@ -0,0 +1,152 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"net"
+	"strings"
+)
+
+// customAnswers are record data templates of block mode custom by record type
+type customAnswers map[uint16]string
+
+// newCustomAnswers parses templates, for example {"A": "192.0.2.1", "TXT": "\"blocked\""}
+func newCustomAnswers(templates map[string]string) (customAnswers, error) {
+	c := make(customAnswers)
+
+	for t, data := range templates {
+		t = strings.ToUpper(t)
+
+		qtype, ok := dns.StringToType[t]
+		if !ok {
+			return nil, fmt.Errorf(`custom block answer: unknown record type %q`, t)
+		}
+
+		// Check that template is valid record data
+		_, err := c.answer(qtype, data, dns.Question{Name: `blocked.invalid.`, Qtype: qtype, Qclass: dns.ClassINET}, 0)
+		if err != nil {
+			return nil, fmt.Errorf(`custom block answer %s: %w`, t, err)
+		}
+
+		c[qtype] = data
+	}
+
+	return c, nil
+}
+
+func (c customAnswers) answer(qtype uint16, data string, q dns.Question, ttl uint32) (dns.RR, error) {
+	return dns.NewRR(fmt.Sprintf(`%s %d %s %s %s`, q.Name, ttl, dns.ClassToString[q.Qclass], dns.Type(qtype).String(), data))
+}
+
+// blockMode gets block mode of query. Mode of a deny rule wins over mode of the client group which wins over the global mode.
+func (s *Service) blockMode(q dns.Question, query *query) string {
+	rule, ok, err := s.db.Match(questionName(q), dns.Type(q.Qtype).String())
+	if err != nil {
+		s.errch <- err
+	}
+
+	if ok && rule.Action == iface.ActionDeny && rule.BlockMode != `` {
+		return rule.BlockMode
+	}
+
+	if query.blockMode != `` {
+		return query.blockMode
+	}
+
+	return s.blockedMode
+}
+
+// blockedAnswer fills resp with answer to blocked question q
+func (s *Service) blockedAnswer(resp *dns.Msg, q dns.Question, mode string) {
+	if metaTypes[q.Qtype] {
+		resp.Rcode = dns.RcodeRefused
+		return
+	}
+
+	hdr := dns.RR_Header{
+		Name:   q.Name,
+		Rrtype: q.Qtype,
+		Class:  q.Qclass,
+		Ttl:    s.bogusTTL,
+	}
+
+	switch mode {
+	case iface.BlockModeRefused:
+		resp.Rcode = dns.RcodeRefused
+
+	case iface.BlockModeNXDomain:
+		resp.Rcode = dns.RcodeNameError
+		resp.Ns = append(resp.Ns, s.negativeSOA(q))
+
+	case iface.BlockModeNoData:
+		resp.Rcode = dns.RcodeSuccess
+		resp.Ns = append(resp.Ns, s.negativeSOA(q))
+
+	case iface.BlockModeCustom:
+		data, ok := s.customAnswers[q.Qtype]
+		if !ok {
+			resp.Rcode = dns.RcodeSuccess
+			resp.Ns = append(resp.Ns, s.negativeSOA(q))
+			return
+		}
+
+		rr, err := s.customAnswers.answer(q.Qtype, data, q, s.bogusTTL)
+		if err != nil {
+			s.errch <- err
+			resp.Rcode = dns.RcodeServerFailure
+			return
+		}
+
+		resp.Answer = append(resp.Answer, rr)
+		resp.Rcode = dns.RcodeSuccess
+
+	default: // Null IP
+		switch q.Qtype {
+		case dns.TypeA:
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: hdr,
+				A:   s.bogusIPv4,
+			})
+
+			resp.Rcode = dns.RcodeSuccess
+
+		case dns.TypeAAAA:
+			resp.Answer = append(resp.Answer, &dns.AAAA{
+				Hdr:  hdr,
+				AAAA: s.bogusIPv6,
+			})
+
+			resp.Rcode = dns.RcodeSuccess
+
+		case dns.TypePTR:
+			addr := net.ParseIP(arpaPTRToString(q.Name))
+
+			if !s.checkIPAddress(addr) {
+				resp.Rcode = dns.RcodeRefused
+				return
+			}
+
+			resp.Answer = append(resp.Answer, &dns.PTR{
+				Hdr: hdr,
+				Ptr: s.bogusPTR,
+			})
+
+			resp.Rcode = dns.RcodeSuccess
+
+		case dns.TypeMX:
+			resp.Answer = append(resp.Answer, &dns.MX{
+				Hdr:        hdr,
+				Preference: 0,
+				Mx:         "spam.mail." + s.bogusPTR,
+			})
+
+			resp.Rcode = dns.RcodeSuccess
+
+		default:
+			// NODATA: name exists but has no records of this type
+			resp.Ns = append(resp.Ns, s.negativeSOA(q))
+			resp.Rcode = dns.RcodeSuccess
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"testing"
+)
+
+func TestBlockedAnswer(t *testing.T) {
+	s := newTestService(t, Config{
+		Blocked: Blocked{
+			IPv4:   `0.0.0.0`,
+			IPv6:   `::`,
+			PTR:    `invalid.`,
+			Custom: map[string]string{`A`: `192.0.2.1`, `TXT`: `"blocked"`},
+		},
+		TTL: 30,
+	})
+
+	tests := []struct {
+		name   string
+		mode   string
+		qname  string
+		qtype  uint16
+		rcode  int
+		answer string // Empty if there is no answer
+		soa    bool   // Authority section has the negative answer SOA
+	}{
+		{`null IP A`, iface.BlockModeNullIP, `ads.example.com.`, dns.TypeA, dns.RcodeSuccess, "ads.example.com.\t30\tIN\tA\t0.0.0.0", false},
+		{`null IP AAAA`, iface.BlockModeNullIP, `ads.example.com.`, dns.TypeAAAA, dns.RcodeSuccess, "ads.example.com.\t30\tIN\tAAAA\t::", false},
+		{`null IP MX`, iface.BlockModeNullIP, `ads.example.com.`, dns.TypeMX, dns.RcodeSuccess, "ads.example.com.\t30\tIN\tMX\t0 spam.mail.invalid.", false},
+		{`null IP PTR`, iface.BlockModeNullIP, `1.2.0.192.in-addr.arpa.`, dns.TypePTR, dns.RcodeSuccess, "1.2.0.192.in-addr.arpa.\t30\tIN\tPTR\tinvalid.", false},
+		{`null IP PTR of loopback`, iface.BlockModeNullIP, `1.0.0.127.in-addr.arpa.`, dns.TypePTR, dns.RcodeRefused, ``, false},
+		{`null IP TXT is NODATA`, iface.BlockModeNullIP, `ads.example.com.`, dns.TypeTXT, dns.RcodeSuccess, ``, true},
+		{`default mode is null IP`, ``, `ads.example.com.`, dns.TypeA, dns.RcodeSuccess, "ads.example.com.\t30\tIN\tA\t0.0.0.0", false},
+		{`NXDOMAIN`, iface.BlockModeNXDomain, `ads.example.com.`, dns.TypeA, dns.RcodeNameError, ``, true},
+		{`NXDOMAIN HTTPS`, iface.BlockModeNXDomain, `ads.example.com.`, dns.TypeHTTPS, dns.RcodeNameError, ``, true},
+		{`NODATA`, iface.BlockModeNoData, `ads.example.com.`, dns.TypeAAAA, dns.RcodeSuccess, ``, true},
+		{`refused`, iface.BlockModeRefused, `ads.example.com.`, dns.TypeA, dns.RcodeRefused, ``, false},
+		{`custom A`, iface.BlockModeCustom, `ads.example.com.`, dns.TypeA, dns.RcodeSuccess, "ads.example.com.\t30\tIN\tA\t192.0.2.1", false},
+		{`custom TXT`, iface.BlockModeCustom, `ads.example.com.`, dns.TypeTXT, dns.RcodeSuccess, "ads.example.com.\t30\tIN\tTXT\t\"blocked\"", false},
+		{`custom without template is NODATA`, iface.BlockModeCustom, `ads.example.com.`, dns.TypeAAAA, dns.RcodeSuccess, ``, true},
+		{`meta type is refused`, iface.BlockModeNXDomain, `ads.example.com.`, dns.TypeANY, dns.RcodeRefused, ``, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &dns.Msg{}
+			req.SetQuestion(tt.qname, tt.qtype)
+
+			resp := &dns.Msg{}
+			resp.SetReply(req)
+
+			s.blockedAnswer(resp, req.Question[0], tt.mode)
+
+			if resp.Rcode != tt.rcode {
+				t.Fatalf(`got rcode %s, want %s`, dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.rcode])
+			}
+
+			var answer string
+			if len(resp.Answer) > 1 {
+				t.Fatalf(`got answer %v`, resp.Answer)
+			} else if len(resp.Answer) == 1 {
+				answer = resp.Answer[0].String()
+			}
+
+			if answer != tt.answer {
+				t.Fatalf(`got answer %q, want %q`, answer, tt.answer)
+			}
+
+			if !tt.soa {
+				if len(resp.Ns) != 0 {
+					t.Fatalf(`got authority %v`, resp.Ns)
+				}
+
+				return
+			}
+
+			if len(resp.Ns) != 1 {
+				t.Fatalf(`got authority %v, want SOA`, resp.Ns)
+			}
+
+			soa, ok := resp.Ns[0].(*dns.SOA)
+			if !ok || soa.Hdr.Name != tt.qname || soa.Minttl != 30 || soa.Hdr.Ttl != 30 {
+				t.Fatalf(`got authority %v`, resp.Ns[0])
+			}
+		})
+	}
+}
+
+func TestBlockMode(t *testing.T) {
+	s := newTestService(t, Config{
+		Blocked: Blocked{IPv4: `0.0.0.0`, IPv6: `::`, PTR: `invalid.`, Mode: iface.BlockModeNoData},
+	})
+
+	err := s.db.Deny(`tracker.example.com`, `A`, iface.RuleOptions{Subtree: true, BlockMode: iface.BlockModeRefused})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.db.Deny(`ads.example.com`, `A`, iface.RuleOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		group string // Block mode of the client group
+		want  string
+	}{
+		{`ads.example.com.`, ``, iface.BlockModeNoData},
+		{`ads.example.com.`, iface.BlockModeNXDomain, iface.BlockModeNXDomain},
+		{`tracker.example.com.`, ``, iface.BlockModeRefused},
+		{`www.tracker.example.com.`, iface.BlockModeNXDomain, iface.BlockModeRefused},
+		{`unknown.example.com.`, iface.BlockModeNullIP, iface.BlockModeNullIP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+` `+tt.group, func(t *testing.T) {
+			got := s.blockMode(dns.Question{Name: tt.name, Qtype: dns.TypeA, Qclass: dns.ClassINET}, &query{blockMode: tt.group})
+			if got != tt.want {
+				t.Fatalf(`got %q, want %q`, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCustomAnswersInvalid(t *testing.T) {
+	for _, templates := range []map[string]string{
+		{`BOGUS`: `192.0.2.1`},
+		{`A`: `not an address`},
+		{`MX`: `mail.example.com.`},
+	} {
+		if _, err := newCustomAnswers(templates); err == nil {
+			t.Errorf(`%v accepted`, templates)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"fmt"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"net"
+)
+
+const defaultClientGroup = `default`
+
+// ClientGroup is a named set of client networks. Clients not in any group belong to group "default".
+// Group "default" can be configured without networks to change settings of those clients.
+type ClientGroup struct {
+	Name      string     `json:"name"`
+	Networks  []string   `json:"networks"`             // CIDR, for example 192.168.1.0/24
+	BlockMode string     `json:"block_mode,omitempty"` // Overrides blocked.mode
+	RateLimit *RateLimit `json:"rate_limit,omitempty"` // Overrides rate_limit
+}
+
+type clientGroup struct {
+	name      string
+	nets      []*net.IPNet
+	blockMode string       // Empty for the global default
+	limiter   *rateLimiter // nil for the global rate limit
+}
+
+var defaultGroup = clientGroup{
+	name: defaultClientGroup,
+}
+
+type clientGroups []clientGroup
+
+func newClientGroups(cfg []ClientGroup) (groups clientGroups, err error) {
+	for _, g := range cfg {
+		if g.Name == `` {
+			return nil, fmt.Errorf(`client group without name`)
+		}
+
+		if g.BlockMode != `` && !iface.ValidBlockMode(g.BlockMode) {
+			return nil, fmt.Errorf(`client group %q: unknown block mode %q`, g.Name, g.BlockMode)
+		}
+
+		cg := clientGroup{
+			name:      g.Name,
+			blockMode: g.BlockMode,
+		}
+
+		if g.RateLimit != nil {
+			cg.limiter, err = newRateLimiter(*g.RateLimit)
+			if err != nil {
+				return nil, fmt.Errorf(`client group %q: %w`, g.Name, err)
+			}
+		}
+
+		for _, n := range g.Networks {
+			_, ipnet, err := net.ParseCIDR(n)
+			if err != nil {
+				return nil, fmt.Errorf(`client group %q: %w`, g.Name, err)
+			}
+
+			cg.nets = append(cg.nets, ipnet)
+		}
+
+		groups = append(groups, cg)
+	}
+
+	return groups, nil
+}
+
+// lookup returns the first group containing ip
+func (g clientGroups) lookup(ip net.IP) clientGroup {
+	if ip != nil {
+		for _, cg := range g {
+			for _, n := range cg.nets {
+				if n.Contains(ip) {
+					return cg
+				}
+			}
+		}
+	}
+
+	for _, cg := range g {
+		if cg.name == defaultClientGroup {
+			return cg
+		}
+	}
+
+	return defaultGroup
+}
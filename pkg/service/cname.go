@@ -0,0 +1,81 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"strings"
+)
+
+// CNAME chain policies
+const (
+	cnameTrust  = `trust`  // Answers along the CNAME chain of an allowed name are allowed
+	cnameStrict = `strict` // Every name in the CNAME chain must be allowed
+)
+
+// cnameChain is the state of checking answer records of a forwarded query
+type cnameChain struct {
+	qname string          // Question name, already allowed
+	qtype uint16          // CNAME hops are checked as this type
+	names map[string]bool // Names reached through the chain, lower case with trailing dot
+}
+
+func newCnameChain(q dns.Question) *cnameChain {
+	qname := strings.ToLower(q.Name)
+
+	return &cnameChain{
+		qname: qname,
+		qtype: q.Qtype,
+		names: map[string]bool{qname: true},
+	}
+}
+
+// checkHop checks answer record rr against the CNAME chain policy and deny rules.
+// reason describes why the record was not allowed.
+func (s *Service) checkHop(c *cnameChain, rr dns.RR) (allowed bool, reason string) {
+	hdr := rr.Header()
+	owner := strings.ToLower(hdr.Name)
+
+	// Hops are checked as the queried type so that an address rule of a CDN name covers its CNAME
+	t := hdr.Rrtype
+	if t == dns.TypeCNAME {
+		t = c.qtype
+	}
+
+	hop := dns.Question{
+		Name:   owner,
+		Qtype:  t,
+		Qclass: hdr.Class,
+	}
+
+	inChain := c.names[owner]
+
+	if cname, ok := rr.(*dns.CNAME); ok && inChain {
+		c.names[strings.ToLower(cname.Target)] = true
+	}
+
+	if owner == c.qname {
+		return true, ``
+	}
+
+	// Deny rules are checked on every hop to catch trackers cloaked behind a first party name
+	rule, ok, err := s.db.Match(questionName(hop), dns.Type(t).String())
+	if err != nil {
+		s.errch <- err
+		return false, fmt.Sprintf(`answer %s %s: %v`, dns.Type(hdr.Rrtype).String(), owner, err)
+	}
+
+	if ok && rule.Action == iface.ActionDeny {
+		return false, fmt.Sprintf(`CNAME chain of %s reaches denied %s`, c.qname, owner)
+	}
+
+	if inChain && s.cnamePolicy == cnameTrust {
+		return true, ``
+	}
+
+	if allowed, _ := s.checkAllowed(hop); !allowed {
+		return false, fmt.Sprintf(`answer %s %s not allowed`, dns.Type(hdr.Rrtype).String(), owner)
+	}
+
+	return true, ``
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"strings"
+	"testing"
+)
+
+func TestCnameChain(t *testing.T) {
+	// Three CNAME hops from the question name to the address
+	chain := []string{
+		`www.site.com. 60 IN CNAME a.cdn.net.`,
+		`a.cdn.net. 60 IN CNAME b.cdn.net.`,
+		`b.cdn.net. 60 IN CNAME c.edge.net.`,
+		`c.edge.net. 60 IN A 192.0.2.1`,
+	}
+
+	tests := []struct {
+		name    string
+		policy  string
+		allow   []string // Subtree A rules
+		deny    []string // Subtree A rules
+		records []string // Extra answer records after the chain
+		reason  string   // Part of the reason of blocking, empty if the answer is passed
+	}{
+		{`trust passes whole chain`, cnameTrust, nil, nil, nil, ``},
+		{`trust denies last hop`, cnameTrust, nil, []string{`edge.net`}, nil, `reaches denied c.edge.net.`},
+		{`trust denies middle hop`, cnameTrust, nil, []string{`b.cdn.net`}, nil, `reaches denied b.cdn.net.`},
+		{`trust checks record outside chain`, cnameTrust, nil, nil, []string{`other.example.net. 60 IN A 192.0.2.2`}, `A other.example.net. not allowed`},
+		{`trust passes allowed record outside chain`, cnameTrust, []string{`example.net`}, nil, []string{`other.example.net. 60 IN A 192.0.2.2`}, ``},
+		{`strict blocks first unallowed hop`, cnameStrict, nil, nil, nil, `CNAME a.cdn.net. not allowed`},
+		{`strict blocks unallowed last hop`, cnameStrict, []string{`cdn.net`}, nil, nil, `A c.edge.net. not allowed`},
+		{`strict passes allowed hops`, cnameStrict, []string{`cdn.net`, `edge.net`}, nil, nil, ``},
+		{`deny wins over allow`, cnameStrict, []string{`cdn.net`, `edge.net`}, []string{`b.cdn.net`}, nil, `reaches denied b.cdn.net.`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := append(append([]string(nil), chain...), tt.records...)
+
+			upstream := serveUpstream(t, func(q dns.Question) (l []dns.RR) {
+				for _, r := range records {
+					l = append(l, mustRR(t, r))
+				}
+
+				return l
+			})
+
+			s := newTestService(t, Config{
+				Forwarders:  []string{upstream},
+				CNAMEPolicy: tt.policy,
+			})
+
+			for _, name := range tt.allow {
+				if err := s.db.Allow(name, `A`, iface.RuleOptions{Subtree: true}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, name := range tt.deny {
+				if err := s.db.Deny(name, `A`, iface.RuleOptions{Subtree: true}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			resp, q := forward(t, s, `www.site.com.`, dns.TypeA)
+
+			if tt.reason == `` {
+				if q.decision != decisionAllowed {
+					t.Fatalf(`got %s: %s`, q.decision, q.reason)
+				}
+
+				if len(resp.Answer) != len(records) {
+					t.Fatalf(`got answer %v`, resp.Answer)
+				}
+
+				return
+			}
+
+			if q.decision != decisionBlocked || !strings.Contains(q.reason, tt.reason) {
+				t.Fatalf(`got %s: %q, want blocked: %q`, q.decision, q.reason, tt.reason)
+			}
+
+			// Blocked with the null IP block mode instead of the forwarded chain
+			if len(resp.Answer) != 1 || resp.Answer[0].String() != "www.site.com.\t60\tIN\tA\t0.0.0.0" {
+				t.Fatalf(`got answer %v`, resp.Answer)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// defaultBackend is the database backend used when none is named
+const defaultBackend = `fs`
+
+// Database selects the storage backend. Each backend reads its options from the block named after it, for example
+//
+//	{"backend": "fs", "fs": {"path": "/var/torjuja"}}
+type Database struct {
+	Backend string // Registered backend name, defaults to fs
+	// Block of backend fs. Its directory is also the default location of the audit trail and local records.
+	FileSystem *struct {
+		Path string `json:"path"`
+	}
+	blocks map[string]json.RawMessage // Backend name -> configuration block
+}
+
+func (d *Database) UnmarshalJSON(b []byte) error {
+	err := json.Unmarshal(b, &d.blocks)
+	if err != nil {
+		return err
+	}
+
+	if raw, ok := d.blocks[`backend`]; ok {
+		err = json.Unmarshal(raw, &d.Backend)
+		if err != nil {
+			return fmt.Errorf(`database backend: %w`, err)
+		}
+
+		delete(d.blocks, `backend`)
+	}
+
+	if raw, ok := d.blocks[defaultBackend]; ok {
+		err = json.Unmarshal(raw, &d.FileSystem)
+		if err != nil {
+			return fmt.Errorf(`database %s: %w`, defaultBackend, err)
+		}
+	}
+
+	return nil
+}
+
+// Block gets configuration block of backend name, nil if there is none
+func (d Database) Block(name string) json.RawMessage {
+	return d.blocks[name]
+}
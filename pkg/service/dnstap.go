@@ -0,0 +1,83 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	"github.com/raspi/torjuja/pkg/dnstap"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Dnstap is the configuration of dnstap output
+type Dnstap struct {
+	Network  string `json:"network"` // unix or tcp
+	Address  string `json:"address"` // Socket path or host:port
+	Identity string `json:"identity,omitempty"`
+	Buffer   int    `json:"buffer"` // Messages buffered before dropping
+}
+
+// tap sends DNS message msg to dnstap collector if one is configured
+func (s *Service) tap(t dnstap.MessageType, client net.Addr, server net.Addr, queryTime time.Time, msg *dns.Msg) {
+	if s.dnstap == nil || msg == nil {
+		return
+	}
+
+	b, err := msg.Pack()
+	if err != nil {
+		s.errch <- err
+		return
+	}
+
+	m := dnstap.Message{
+		Type:      t,
+		QueryTime: queryTime,
+	}
+
+	var protocol uint64
+
+	m.QueryAddress, m.QueryPort, m.Protocol = splitAddr(client)
+	m.ResponseAddress, m.ResponsePort, protocol = splitAddr(server)
+
+	if m.Protocol == 0 {
+		m.Protocol = protocol
+	}
+
+	switch t {
+	case dnstap.ClientQuery, dnstap.ForwarderQuery:
+		m.QueryMessage = b
+	default:
+		m.ResponseTime = time.Now()
+		m.ResponseMessage = b
+	}
+
+	if !s.dnstap.Send(m) {
+		s.metrics.dnstapDropped.Inc()
+	}
+}
+
+// splitAddr returns IP, port and dnstap socket protocol of addr
+func splitAddr(addr net.Addr) (ip net.IP, port uint32, protocol uint64) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP, uint32(a.Port), dnstap.SocketProtocolUDP
+	case *net.TCPAddr:
+		return a.IP, uint32(a.Port), dnstap.SocketProtocolTCP
+	}
+
+	return nil, 0, 0
+}
+
+// forwarderAddr converts forwarder host:port to net.Addr
+func forwarderAddr(upstream string) net.Addr {
+	host, port, err := net.SplitHostPort(upstream)
+	if err != nil {
+		return nil
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil
+	}
+
+	return &net.UDPAddr{IP: net.ParseIP(host), Port: p}
+}
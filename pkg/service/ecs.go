@@ -0,0 +1,113 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+)
+
+// ECS modes
+const (
+	ecsStrip = `strip` // No client subnet is sent to forwarders
+	ecsAdd   = `add`   // Truncated client subnet or the configured subnet is sent to forwarders
+)
+
+// ecsUDPSize is the UDP payload size advertised in forwarded queries carrying EDNS options
+const ecsUDPSize = 1232
+
+// ECS is the EDNS Client Subnet (RFC 7871) policy of forwarded queries.
+// Subnets sent by clients are never forwarded.
+type ECS struct {
+	Mode       string `json:"mode"`             // strip (default) or add
+	Subnet     string `json:"subnet,omitempty"` // Sent instead of client subnets, for example 198.51.100.0/24
+	IPv4Prefix uint8  `json:"ipv4_prefix"`      // Client IPv4 addresses are truncated to this, defaults to 24
+	IPv6Prefix uint8  `json:"ipv6_prefix"`      // Client IPv6 addresses are truncated to this, defaults to 56
+	subnet     *net.IPNet
+}
+
+func (e *ECS) validate() error {
+	switch e.Mode {
+	case ``:
+		e.Mode = ecsStrip
+	case ecsStrip, ecsAdd:
+	default:
+		return fmt.Errorf(`ecs: unknown mode %q`, e.Mode)
+	}
+
+	if e.IPv4Prefix == 0 {
+		e.IPv4Prefix = 24
+	}
+
+	if e.IPv6Prefix == 0 {
+		e.IPv6Prefix = 56
+	}
+
+	if e.IPv4Prefix > 32 || e.IPv6Prefix > 128 {
+		return fmt.Errorf(`ecs: invalid prefix length`)
+	}
+
+	if e.Subnet != `` {
+		nets, err := parseNets([]string{e.Subnet})
+		if err != nil {
+			return fmt.Errorf(`ecs: %w`, err)
+		}
+
+		e.subnet = nets[0]
+	}
+
+	return nil
+}
+
+// ecsSubnet gets the client subnet option sent with queries of client, nil if none is sent.
+// Private client addresses are never sent as forwarders can't use them.
+func (s *Service) ecsSubnet(client net.IP) *dns.EDNS0_SUBNET {
+	if s.ecs == nil || s.ecs.Mode != ecsAdd {
+		return nil
+	}
+
+	var ip net.IP
+	var prefix int
+
+	if s.ecs.subnet != nil {
+		ip = s.ecs.subnet.IP
+		prefix, _ = s.ecs.subnet.Mask.Size()
+	} else {
+		if client == nil || isPrivate(client) || !s.checkIPAddress(client) {
+			return nil
+		}
+
+		ip = client
+		prefix = int(s.ecs.IPv6Prefix)
+
+		if ip.To4() != nil {
+			prefix = int(s.ecs.IPv4Prefix)
+		}
+	}
+
+	o := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		SourceNetmask: uint8(prefix),
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		o.Family = 1
+		o.Address = ip4.Mask(net.CIDRMask(prefix, 32))
+	} else {
+		o.Family = 2
+		o.Address = ip.Mask(net.CIDRMask(prefix, 128))
+	}
+
+	return o
+}
+
+// setECS adds client subnet option of client to forwarded query req if the policy sends one
+func (s *Service) setECS(req *dns.Msg, client net.IP) {
+	o := s.ecsSubnet(client)
+	if o == nil {
+		return
+	}
+
+	req.SetEdns0(ecsUDPSize, false)
+	opt := req.IsEdns0()
+	opt.Option = append(opt.Option, o)
+}
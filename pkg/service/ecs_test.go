@@ -0,0 +1,147 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"net"
+	"sync"
+	"testing"
+)
+
+// subnetString formats client subnet option o as address/prefix, empty if o is nil
+func subnetString(o *dns.EDNS0_SUBNET) string {
+	if o == nil {
+		return ``
+	}
+
+	bits := 128
+	if o.Family == 1 {
+		bits = 32
+	}
+
+	return (&net.IPNet{IP: o.Address, Mask: net.CIDRMask(int(o.SourceNetmask), bits)}).String()
+}
+
+func TestECSSubnet(t *testing.T) {
+	tests := []struct {
+		name   string
+		ecs    *ECS
+		client string
+		want   string
+	}{
+		{`not configured`, nil, `198.51.100.77`, ``},
+		{`strip`, &ECS{Mode: ecsStrip}, `198.51.100.77`, ``},
+		{`default IPv4 prefix`, &ECS{Mode: ecsAdd}, `198.51.100.77`, `198.51.100.0/24`},
+		{`default IPv6 prefix`, &ECS{Mode: ecsAdd}, `2001:db8:1234:5678::1`, `2001:db8:1234:5600::/56`},
+		{`IPv4 prefix`, &ECS{Mode: ecsAdd, IPv4Prefix: 20}, `198.51.100.77`, `198.51.96.0/20`},
+		{`IPv6 prefix`, &ECS{Mode: ecsAdd, IPv6Prefix: 48}, `2001:db8:1234:5678::1`, `2001:db8:1234::/48`},
+		{`private IPv4 client`, &ECS{Mode: ecsAdd}, `192.168.1.10`, ``},
+		{`shared address space client`, &ECS{Mode: ecsAdd}, `100.64.1.1`, ``},
+		{`unique local IPv6 client`, &ECS{Mode: ecsAdd}, `fd00::1`, ``},
+		{`loopback client`, &ECS{Mode: ecsAdd}, `127.0.0.1`, ``},
+		{`link local client`, &ECS{Mode: ecsAdd}, `fe80::1`, ``},
+		{`no client`, &ECS{Mode: ecsAdd}, ``, ``},
+		{`configured subnet`, &ECS{Mode: ecsAdd, Subnet: `203.0.113.0/24`}, `192.168.1.10`, `203.0.113.0/24`},
+		{`configured subnet is truncated`, &ECS{Mode: ecsAdd, Subnet: `203.0.113.99/26`}, `198.51.100.77`, `203.0.113.64/26`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, Config{ECS: tt.ecs})
+
+			if got := subnetString(s.ecsSubnet(net.ParseIP(tt.client))); got != tt.want {
+				t.Fatalf(`got %q, want %q`, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestECSValidateInvalid(t *testing.T) {
+	for _, e := range []ECS{
+		{Mode: `forward`},
+		{Mode: ecsAdd, IPv4Prefix: 33},
+		{Mode: ecsAdd, IPv6Prefix: 129},
+		{Mode: ecsAdd, Subnet: `203.0.113.0/33`},
+	} {
+		e := e
+		if err := e.validate(); err == nil {
+			t.Errorf(`%+v accepted`, e)
+		}
+	}
+}
+
+func TestECSForwarded(t *testing.T) {
+	var mu sync.Mutex
+	var forwarded *dns.Msg
+
+	upstream := serveUpstreamHandler(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		mu.Lock()
+		forwarded = req
+		mu.Unlock()
+
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		_ = w.WriteMsg(resp)
+	}))
+
+	tests := []struct {
+		name   string
+		mode   string
+		client string
+		want   string // Subnet seen by the forwarder
+	}{
+		{`strip`, ecsStrip, `198.51.100.77`, ``},
+		{`add`, ecsAdd, `198.51.100.77`, `198.51.100.0/24`},
+		{`add private client`, ecsAdd, `192.168.1.10`, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, Config{
+				Forwarders: []string{upstream},
+				ECS:        &ECS{Mode: tt.mode},
+			})
+
+			if err := s.db.Allow(`www.example.com`, `A`, iface.RuleOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			// Subnet sent by the client is never forwarded
+			req := &dns.Msg{}
+			req.SetQuestion(`www.example.com.`, dns.TypeA)
+			req.SetEdns0(4096, false)
+			req.IsEdns0().Option = append(req.IsEdns0().Option, &dns.EDNS0_SUBNET{
+				Code:          dns.EDNS0SUBNET,
+				Family:        1,
+				SourceNetmask: 32,
+				Address:       net.ParseIP(`203.0.113.1`).To4(),
+			})
+
+			q := newQuery(&net.UDPAddr{IP: net.ParseIP(tt.client)}, req)
+
+			if _, _, err := s.checkDnsRequest(req, q); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if forwarded == nil {
+				t.Fatal(`nothing forwarded`)
+			}
+
+			var subnets []string
+			if opt := forwarded.IsEdns0(); opt != nil {
+				for _, o := range opt.Option {
+					if o, ok := o.(*dns.EDNS0_SUBNET); ok {
+						subnets = append(subnets, subnetString(o))
+					}
+				}
+			}
+
+			if tt.want == `` && len(subnets) != 0 || tt.want != `` && (len(subnets) != 1 || subnets[0] != tt.want) {
+				t.Fatalf(`forwarder got subnets %q, want %q`, subnets, tt.want)
+			}
+		})
+	}
+}
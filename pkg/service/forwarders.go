@@ -0,0 +1,123 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+)
+
+// Forwarder selection strategies
+const (
+	strategyFailover   = `failover`    // In configured order, next one is tried if previous fails
+	strategyRoundRobin = `round-robin` // Starting from the next one for each query, others on failure
+	strategyRandom     = `random`      // Random order for each query
+)
+
+// ForwardZone sends queries of a domain and its subdomains to forwarders of its own
+type ForwardZone struct {
+	Domain     string   `json:"domain"` // For example corp.example or 10.in-addr.arpa
+	Forwarders []string `json:"forwarders"`
+	Strategy   string   `json:"strategy"` // failover (default), round-robin or random
+}
+
+func validStrategy(s string) bool {
+	switch s {
+	case strategyFailover, strategyRoundRobin, strategyRandom:
+		return true
+	}
+
+	return false
+}
+
+// upstreams is a set of forwarders used with a strategy
+type upstreams struct {
+	next     uint32 // Round-robin position, first field for atomic alignment
+	addrs    []string
+	strategy string
+}
+
+// order gets forwarders in the order they are tried for a query
+func (u *upstreams) order() []string {
+	l := make([]string, len(u.addrs))
+
+	switch u.strategy {
+	case strategyRoundRobin:
+		start := int(atomic.AddUint32(&u.next, 1)-1) % len(u.addrs)
+
+		for i := range u.addrs {
+			l[i] = u.addrs[(start+i)%len(u.addrs)]
+		}
+	case strategyRandom:
+		for i, j := range rand.Perm(len(u.addrs)) {
+			l[i] = u.addrs[j]
+		}
+	default:
+		copy(l, u.addrs)
+	}
+
+	return l
+}
+
+// forwardZones maps domains to their forwarders
+type forwardZones struct {
+	zones map[string]*upstreams // FQDN with trailing dot
+	def   *upstreams            // For names not in any zone
+}
+
+func newForwardZones(forwarders []string, strategy string, cfg []ForwardZone) (z forwardZones, err error) {
+	if strategy == `` {
+		strategy = strategyFailover
+	}
+
+	if !validStrategy(strategy) {
+		return z, fmt.Errorf(`unknown forwarder strategy %q`, strategy)
+	}
+
+	z = forwardZones{
+		zones: make(map[string]*upstreams),
+		def:   &upstreams{addrs: forwarders, strategy: strategy},
+	}
+
+	for _, fz := range cfg {
+		domain := dns.Fqdn(strings.ToLower(strings.TrimSpace(fz.Domain)))
+
+		if _, ok := dns.IsDomainName(domain); !ok || domain == `.` {
+			return z, fmt.Errorf(`forward zone: invalid domain %q`, fz.Domain)
+		}
+
+		if len(fz.Forwarders) == 0 {
+			return z, fmt.Errorf(`forward zone %q: no forwarders`, fz.Domain)
+		}
+
+		if fz.Strategy == `` {
+			fz.Strategy = strategyFailover
+		}
+
+		if !validStrategy(fz.Strategy) {
+			return z, fmt.Errorf(`forward zone %q: unknown strategy %q`, fz.Domain, fz.Strategy)
+		}
+
+		if _, ok := z.zones[domain]; ok {
+			return z, fmt.Errorf(`forward zone %q: defined twice`, fz.Domain)
+		}
+
+		z.zones[domain] = &upstreams{addrs: fz.Forwarders, strategy: fz.Strategy}
+	}
+
+	return z, nil
+}
+
+// lookup finds forwarders of the longest matching zone of name. zone is empty for the default forwarders.
+func (z forwardZones) lookup(name string) (zone string, u *upstreams) {
+	name = dns.Fqdn(strings.ToLower(name))
+
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if u, ok := z.zones[name[off:]]; ok {
+			return name[off:], u
+		}
+	}
+
+	return ``, z.def
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestForwardZonesLookup(t *testing.T) {
+	z, err := newForwardZones([]string{`192.0.2.53:53`}, ``, []ForwardZone{
+		{Domain: `corp.example`, Forwarders: []string{`10.0.0.53:53`}},
+		{Domain: `Lab.Corp.Example.`, Forwarders: []string{`10.1.0.53:53`}},
+		{Domain: `10.in-addr.arpa`, Forwarders: []string{`10.0.0.53:53`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		zone      string
+		forwarder string
+	}{
+		{`corp.example.`, `corp.example.`, `10.0.0.53:53`},
+		{`www.corp.example`, `corp.example.`, `10.0.0.53:53`},
+		{`lab.corp.example.`, `lab.corp.example.`, `10.1.0.53:53`},
+		{`HOST.LAB.corp.example.`, `lab.corp.example.`, `10.1.0.53:53`},
+		{`notcorp.example.`, ``, `192.0.2.53:53`},
+		{`example.`, ``, `192.0.2.53:53`},
+		{`1.2.3.10.in-addr.arpa.`, `10.in-addr.arpa.`, `10.0.0.53:53`},
+		{`1.2.3.11.in-addr.arpa.`, ``, `192.0.2.53:53`},
+		{`.`, ``, `192.0.2.53:53`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone, u := z.lookup(tt.name)
+
+			if zone != tt.zone || u.order()[0] != tt.forwarder {
+				t.Fatalf(`got zone %q forwarders %v, want %q %s`, zone, u.addrs, tt.zone, tt.forwarder)
+			}
+		})
+	}
+}
+
+func TestNewForwardZonesInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		zones    []ForwardZone
+	}{
+		{`unknown strategy`, `fastest`, nil},
+		{`root zone`, ``, []ForwardZone{{Domain: `.`, Forwarders: []string{`10.0.0.53:53`}}}},
+		{`invalid domain`, ``, []ForwardZone{{Domain: `corp..example`, Forwarders: []string{`10.0.0.53:53`}}}},
+		{`no forwarders`, ``, []ForwardZone{{Domain: `corp.example`}}},
+		{`unknown zone strategy`, ``, []ForwardZone{{Domain: `corp.example`, Forwarders: []string{`10.0.0.53:53`}, Strategy: `fastest`}}},
+		{`defined twice`, ``, []ForwardZone{
+			{Domain: `corp.example`, Forwarders: []string{`10.0.0.53:53`}},
+			{Domain: `CORP.example.`, Forwarders: []string{`10.0.0.54:53`}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newForwardZones([]string{`192.0.2.53:53`}, tt.strategy, tt.zones); err == nil {
+				t.Fatal(`no error`)
+			}
+		})
+	}
+}
+
+func TestUpstreamsOrder(t *testing.T) {
+	addrs := []string{`a:53`, `b:53`, `c:53`}
+
+	tests := []struct {
+		strategy string
+		want     []string // Orders of consecutive queries, empty to only check the order is a permutation
+	}{
+		{strategyFailover, []string{`a b c`, `a b c`, `a b c`}},
+		{strategyRoundRobin, []string{`a b c`, `b c a`, `c a b`, `a b c`}},
+		{strategyRandom, []string{``, ``, ``}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			u := &upstreams{addrs: addrs, strategy: tt.strategy}
+
+			for i, want := range tt.want {
+				order := u.order()
+
+				sorted := append([]string(nil), order...)
+				sort.Strings(sorted)
+
+				if strings.Join(sorted, ` `) != strings.Join(addrs, ` `) {
+					t.Fatalf(`query %d: got %v, not all forwarders once`, i, order)
+				}
+
+				got := strings.ReplaceAll(strings.Join(order, ` `), `:53`, ``)
+				if want != `` && got != want {
+					t.Fatalf(`query %d: got %q, want %q`, i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardZoneQuery(t *testing.T) {
+	answer := func(addr string) func(q dns.Question) []dns.RR {
+		return func(q dns.Question) []dns.RR {
+			return []dns.RR{mustRR(t, q.Name+` 60 IN A `+addr)}
+		}
+	}
+
+	public := serveUpstream(t, answer(`93.184.216.34`))
+	corp := serveUpstream(t, answer(`10.0.0.1`))
+
+	s := newTestService(t, Config{
+		Forwarders:   []string{public},
+		ForwardZones: []ForwardZone{{Domain: `corp.example`, Forwarders: []string{corp}}},
+	})
+
+	tests := []struct {
+		name     string
+		upstream string
+		addr     string
+	}{
+		{`www.example.com.`, public, `93.184.216.34`},
+		{`intranet.corp.example.`, corp, `10.0.0.1`},
+		{`corp.example.com.`, public, `93.184.216.34`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, q := forward(t, s, tt.name, dns.TypeA)
+
+			if q.upstream != tt.upstream {
+				t.Fatalf(`got forwarder %s, want %s`, q.upstream, tt.upstream)
+			}
+
+			if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != tt.addr {
+				t.Fatalf(`got answer %v, want %s`, resp.Answer, tt.addr)
+			}
+		})
+	}
+}
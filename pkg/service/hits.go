@@ -0,0 +1,77 @@
+package service
+
+import (
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"sync"
+	"time"
+)
+
+// hitCounter batches rule hits in memory so that the database isn't written on every query
+type hitCounter struct {
+	mu   sync.Mutex
+	hits map[hitKey]*iface.Hit
+}
+
+type hitKey struct {
+	name string
+	t    string
+}
+
+func newHitCounter() *hitCounter {
+	return &hitCounter{
+		hits: make(map[hitKey]*iface.Hit),
+	}
+}
+
+// add records a single match of rule for record type t on name
+func (c *hitCounter) add(name string, t string) {
+	now := time.Now()
+	k := hitKey{name: name, t: t}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.hits[k]
+	if !ok {
+		h = &iface.Hit{
+			Name: name,
+			Type: t,
+		}
+
+		c.hits[k] = h
+	}
+
+	h.Count++
+	h.LastSeen = now
+}
+
+// take returns batched hits and resets the counter
+func (c *hitCounter) take() (batch []iface.Hit) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, h := range c.hits {
+		batch = append(batch, *h)
+	}
+
+	c.hits = make(map[hitKey]*iface.Hit)
+
+	return batch
+}
+
+// flushHits periodically writes batched hits to Service.db
+func (s *Service) flushHits(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for range t.C {
+		batch := s.hits.take()
+		if len(batch) == 0 {
+			continue
+		}
+
+		if err := s.db.RecordHits(batch); err != nil {
+			s.errch <- err
+		}
+	}
+}
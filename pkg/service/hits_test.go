@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"github.com/raspi/torjuja/pkg/db/memdb"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestHitCounter(t *testing.T) {
+	tests := []struct {
+		name string
+		add  []hitKey
+		want []string // "type name count"
+	}{
+		{`empty`, nil, nil},
+		{`single`, []hitKey{{`example.com`, `A`}}, []string{`A example.com 1`}},
+		{`batched`, []hitKey{{`example.com`, `A`}, {`example.com`, `A`}, {`example.com`, `A`}}, []string{`A example.com 3`}},
+		{`per name and type`, []hitKey{{`example.com`, `A`}, {`example.com`, `AAAA`}, {`www.example.com`, `A`}, {`example.com`, `A`}},
+			[]string{`A example.com 2`, `A www.example.com 1`, `AAAA example.com 1`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newHitCounter()
+			before := time.Now()
+
+			for _, k := range tt.add {
+				c.add(k.name, k.t)
+			}
+
+			batch := c.take()
+
+			var got []string
+			for _, h := range batch {
+				got = append(got, fmt.Sprintf(`%s %s %d`, h.Type, h.Name, h.Count))
+
+				if h.LastSeen.Before(before) {
+					t.Fatalf(`%s %s last seen %v before the hits`, h.Type, h.Name, h.LastSeen)
+				}
+			}
+
+			sort.Strings(got)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf(`got %q, want %q`, got, tt.want)
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf(`got %q, want %q`, got, tt.want)
+				}
+			}
+
+			if l := c.take(); len(l) != 0 {
+				t.Fatalf(`got %d hits after take`, len(l))
+			}
+		})
+	}
+}
+
+func TestFlushHits(t *testing.T) {
+	db := memdb.New()
+
+	if err := db.Allow(`example.com`, `A`, iface.RuleOptions{Subtree: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Service{db: db, hits: newHitCounter(), errch: make(chan error, 1)}
+	s.hits.add(`www.example.com`, `A`)
+	s.hits.add(`example.com`, `A`)
+
+	go s.flushHits(10 * time.Millisecond)
+
+	deadline := time.Now().Add(2 * time.Second)
+
+	for time.Now().Before(deadline) {
+		r, _, err := db.Rule(`example.com`, `A`, iface.ActionAllow)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if r.Hits == 2 {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal(`hits not written to the database`)
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/raspi/torjuja/pkg/hostnames"
+	"net"
+	"strings"
+	"time"
+)
+
+// Hosts is the configuration of names read from hosts and DHCP lease files
+type Hosts struct {
+	Domain   string      `json:"domain"` // Appended to single label names, for example lan
+	Files    []HostsFile `json:"files"`
+	Interval uint32      `json:"interval"` // Seconds between checking files for changes
+}
+
+type HostsFile struct {
+	Path   string `json:"path"`
+	Format string `json:"format"` // hosts, dnsmasq, dhcpd or kea
+}
+
+func (h *Hosts) validate() error {
+	h.Domain = strings.Trim(strings.ToLower(h.Domain), `.`)
+
+	if h.Domain != `` {
+		if _, ok := dns.IsDomainName(h.Domain); !ok {
+			return fmt.Errorf(`hosts: invalid domain %q`, h.Domain)
+		}
+	}
+
+	for _, f := range h.Files {
+		if !hostnames.ValidFormat(f.Format) {
+			return fmt.Errorf(`hosts: %s: unknown format %q`, f.Path, f.Format)
+		}
+	}
+
+	if h.Interval == 0 {
+		h.Interval = 10
+	}
+
+	return nil
+}
+
+// watchHosts keeps local data up to date with hosts and lease files
+func (s *Service) watchHosts() {
+	if s.hosts.Domain != `` {
+		s.local.SetSource(`domain `+s.hosts.Domain, []dns.RR{s.domainSOA(s.hosts.Domain)})
+	}
+
+	for _, f := range s.hosts.Files {
+		go func(f HostsFile) {
+			hostnames.Watch(f.Path, f.Format, time.Duration(s.hosts.Interval)*time.Second, func(hosts []hostnames.Host) {
+				s.local.SetSource(f.Path, s.hostRecords(hosts))
+			}, s.errch)
+		}(f)
+	}
+}
+
+// hostRecords converts hosts to A and AAAA records. Single label names are placed under the local domain.
+func (s *Service) hostRecords(hosts []hostnames.Host) (rrs []dns.RR) {
+	for _, h := range hosts {
+		name := strings.ToLower(strings.TrimSuffix(h.Name, `.`))
+
+		if !strings.Contains(name, `.`) {
+			if s.hosts.Domain == `` {
+				continue
+			}
+
+			name += `.` + s.hosts.Domain
+		}
+
+		name = dns.Fqdn(name)
+
+		if _, ok := dns.IsDomainName(name); !ok {
+			continue
+		}
+
+		hdr := dns.RR_Header{
+			Name:  name,
+			Class: dns.ClassINET,
+			Ttl:   s.bogusTTL,
+		}
+
+		if ip4 := h.IP.To4(); ip4 != nil {
+			hdr.Rrtype = dns.TypeA
+			rrs = append(rrs, &dns.A{Hdr: hdr, A: ip4})
+		} else {
+			hdr.Rrtype = dns.TypeAAAA
+			rrs = append(rrs, &dns.AAAA{Hdr: hdr, AAAA: h.IP})
+		}
+	}
+
+	return rrs
+}
+
+// domainSOA makes local data authoritative for domain so that unknown names get NXDOMAIN instead of being forwarded
+func (s *Service) domainSOA(domain string) *dns.SOA {
+	soa := s.negativeSOA(dns.Question{Name: dns.Fqdn(domain)})
+	soa.Serial = uint32(time.Now().Unix())
+	return soa
+}
+
+// clientHostname gets local name of client address, empty if unknown
+func (s *Service) clientHostname(client string) string {
+	if s.local == nil {
+		return ``
+	}
+
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return ``
+	}
+
+	return s.local.Hostname(ip)
+}
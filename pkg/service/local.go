@@ -0,0 +1,88 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+)
+
+// answerLocal answers request from local data before allow rules are checked.
+// Returns nil if the question isn't in local data.
+func (s *Service) answerLocal(req *dns.Msg, query *query) (resp *dns.Msg, err error) {
+	if s.local == nil || len(req.Question) != 1 {
+		return nil, nil
+	}
+
+	q := req.Question[0]
+
+	a, ok := s.local.Lookup(q)
+	if !ok {
+		return s.answerPrivatePTR(req, query), nil
+	}
+
+	query.decision = decisionLocal
+	query.rule = `local ` + strings.ToLower(strings.TrimRight(q.Name, `.`))
+
+	resp = &dns.Msg{}
+	resp.SetReply(req)
+	resp.Authoritative = true
+	resp.RecursionAvailable = true
+	resp.Rcode = a.Rcode
+	resp.Answer = a.Answer
+	resp.Ns = a.Ns
+
+	if a.Target == `` {
+		return resp, nil
+	}
+
+	// Local CNAME points outside local data, target is resolved like any other query
+	target := req.Copy()
+	target.Question = []dns.Question{{
+		Name:   a.Target,
+		Qtype:  q.Qtype,
+		Qclass: q.Qclass,
+	}}
+
+	reply, _, err := s.checkDnsRequest(target, query)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Authoritative = false
+	resp.Rcode = reply.Rcode
+	resp.Answer = append(resp.Answer, reply.Answer...)
+	resp.Ns = reply.Ns
+
+	return resp, nil
+}
+
+// answerPrivatePTR answers NXDOMAIN to reverse lookups of private addresses not in local data
+// when hosts are configured, as upstream resolvers can't know them. Returns nil for other questions.
+func (s *Service) answerPrivatePTR(req *dns.Msg, query *query) *dns.Msg {
+	q := req.Question[0]
+
+	if s.hosts == nil || q.Qtype != dns.TypePTR {
+		return nil
+	}
+
+	ip := net.ParseIP(questionName(q))
+	if ip == nil || !isPrivate(ip) {
+		return nil
+	}
+
+	// Reverse zone with forwarders of its own
+	if zone, _ := s.forwardZones.lookup(q.Name); zone != `` {
+		return nil
+	}
+
+	query.decision = decisionLocal
+	query.rule = `local private address`
+
+	resp := &dns.Msg{}
+	resp.SetReply(req)
+	resp.RecursionAvailable = true
+	resp.Rcode = dns.RcodeNameError
+	resp.Ns = append(resp.Ns, s.negativeSOA(q))
+
+	return resp
+}
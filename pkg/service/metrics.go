@@ -0,0 +1,51 @@
+package service
+
+import (
+	"github.com/raspi/torjuja/pkg/metrics"
+)
+
+// serviceMetrics are the Prometheus metrics exported at /metrics
+type serviceMetrics struct {
+	registry          *metrics.Registry
+	queries           *metrics.CounterVec   // qtype, decision, group
+	queryDuration     *metrics.HistogramVec // decision
+	forwarderRequests *metrics.CounterVec   // upstream
+	forwarderErrors   *metrics.CounterVec   // upstream
+	forwarderDuration *metrics.HistogramVec // upstream
+	forwarderMismatch *metrics.CounterVec   // upstream, reason
+	dbLookupDuration  *metrics.HistogramVec // type
+	dnstapDropped     *metrics.CounterVec
+	rebinding         *metrics.CounterVec // action
+	responseIP        *metrics.CounterVec // action
+	rpz               *metrics.CounterVec // action
+}
+
+func newServiceMetrics() *serviceMetrics {
+	r := metrics.NewRegistry()
+
+	return &serviceMetrics{
+		registry: r,
+		queries: r.NewCounterVec(`torjuja_queries_total`,
+			`DNS queries received`, `qtype`, `decision`, `group`),
+		queryDuration: r.NewHistogramVec(`torjuja_query_duration_seconds`,
+			`Time taken to answer a DNS query`, metrics.DefaultBuckets, `decision`),
+		forwarderRequests: r.NewCounterVec(`torjuja_forwarder_requests_total`,
+			`DNS queries sent to forwarders`, `upstream`),
+		forwarderErrors: r.NewCounterVec(`torjuja_forwarder_errors_total`,
+			`Failed DNS queries sent to forwarders`, `upstream`),
+		forwarderDuration: r.NewHistogramVec(`torjuja_forwarder_duration_seconds`,
+			`Round trip time of forwarder queries`, metrics.DefaultBuckets, `upstream`),
+		forwarderMismatch: r.NewCounterVec(`torjuja_forwarder_mismatch_total`,
+			`Forwarder replies ignored because they didn't match the query`, `upstream`, `reason`),
+		dbLookupDuration: r.NewHistogramVec(`torjuja_db_lookup_duration_seconds`,
+			`Time taken to look up a rule from the database`, metrics.FastBuckets, `type`),
+		dnstapDropped: r.NewCounterVec(`torjuja_dnstap_dropped_total`,
+			`dnstap messages dropped because the collector is slow or unavailable`),
+		rebinding: r.NewCounterVec(`torjuja_rebinding_total`,
+			`Forwarded answers with non-public addresses for names outside internal domains`, `action`),
+		responseIP: r.NewCounterVec(`torjuja_response_ip_total`,
+			`Addresses in forwarded answers matching response IP policy`, `action`),
+		rpz: r.NewCounterVec(`torjuja_rpz_total`,
+			`Queries answered by response policy zones`, `action`),
+	}
+}
@@ -0,0 +1,33 @@
+package service
+
+import "net"
+
+// PrivateNets are the private address ranges: RFC 1918, shared address space of RFC 6598 and unique local addresses.
+// Their reverse lookups are never forwarded when hosts are configured, forwarded answers can't rebind public names
+// to them and their clients may query listeners without an access control list.
+var PrivateNets = []string{`10.0.0.0/8`, `172.16.0.0/12`, `192.168.0.0/16`, `100.64.0.0/10`, `fc00::/7`}
+
+var privateNets = mustParseCIDRs(PrivateNets...)
+
+func mustParseCIDRs(cidrs ...string) (l []*net.IPNet) {
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+
+		l = append(l, n)
+	}
+
+	return l
+}
+
+func isPrivate(ip net.IP) bool {
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	"github.com/raspi/torjuja/pkg/httpapi/frontend"
+	"github.com/raspi/torjuja/pkg/querylog"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	decisionAllowed = `allowed`
+	decisionBlocked = `blocked`
+	decisionError   = `error`
+	decisionLocal   = `local` // Answered from local data
+	decisionLimited = `rate-limited`
+	decisionDenied  = `acl-denied` // Client not allowed by the listener access control list
+)
+
+// query is the state of a single client DNS request while it is being resolved.
+// It's filled by checkDnsRequest and queryForwarder and recorded when the reply has been sent.
+type query struct {
+	start     time.Time
+	client    net.IP
+	group     string // Client group
+	blockMode string // Block mode of the client group, empty for the global default
+	hostname  string // Local name of the client, empty if unknown
+	name      string // Question name without trailing dot
+	qtype     uint16
+	decision  string
+	rule      string // Rule that allowed or denied the query
+	reason    string // Why the query was blocked
+	upstream  string // Forwarder used, empty if not forwarded
+	answerIP  net.IP // Address in the forwarded answer that blocked the query
+	rcode     int
+}
+
+func newQuery(remote net.Addr, req *dns.Msg) *query {
+	q := &query{
+		start:    time.Now(),
+		decision: decisionBlocked,
+		rcode:    -1,
+	}
+
+	switch addr := remote.(type) {
+	case *net.UDPAddr:
+		q.client = addr.IP
+	case *net.TCPAddr:
+		q.client = addr.IP
+	}
+
+	if len(req.Question) > 0 {
+		q.name = strings.ToLower(strings.TrimRight(req.Question[0].Name, `.`))
+		q.qtype = req.Question[0].Qtype
+	}
+
+	return q
+}
+
+// clientString returns client IP address as string
+func (q *query) clientString() string {
+	if q.client == nil {
+		return ``
+	}
+
+	return q.client.String()
+}
+
+// record passes finished query to the subsystems collecting them
+func (s *Service) record(q *query) {
+	dur := time.Since(q.start)
+
+	s.stats.add(q, dur)
+	s.metrics.queries.Inc(dns.Type(q.qtype).String(), q.decision, q.group)
+	s.metrics.queryDuration.Observe(dur.Seconds(), q.decision)
+
+	// Floods of rate limited and unauthorized queries are only counted
+	if q.decision == decisionLimited || q.decision == decisionDenied {
+		return
+	}
+
+	s.httpfrontend.SendEvent(frontend.EventDTO{
+		Time:     q.start.Format(time.RFC3339),
+		Client:   q.clientString(),
+		Hostname: q.hostname,
+		Name:     q.name,
+		Type:     dns.Type(q.qtype).String(),
+		Decision: q.decision,
+		Reason:   q.reason,
+		Rule:     q.rule,
+		IP:       ipString(q.answerIP),
+	})
+
+	if s.querylog != nil {
+		err := s.querylog.Add(querylog.Entry{
+			Time:     q.start,
+			Client:   q.clientString(),
+			Hostname: q.hostname,
+			Name:     q.name,
+			Type:     dns.Type(q.qtype).String(),
+			Decision: q.decision,
+			Rule:     q.rule,
+			Upstream: q.upstream,
+			Rcode:    rcodeString(q.rcode),
+			Latency:  float64(dur.Microseconds()) / 1000,
+		})
+
+		if err != nil {
+			s.errch <- err
+		}
+	}
+}
+
+// ipString returns ip as string, empty for nil
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ``
+	}
+
+	return ip.String()
+}
+
+// rcodeString returns name of DNS response code, empty if no reply was sent
+func rcodeString(rcode int) string {
+	if rcode < 0 {
+		return ``
+	}
+
+	return dns.RcodeToString[rcode]
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"sync"
+	"time"
+)
+
+// RateLimit is the configuration of token bucket rate limiting of queries per client address and subnet
+type RateLimit struct {
+	QPS         float64 `json:"qps"`          // Queries per second per client address, 0 for no limit
+	Burst       float64 `json:"burst"`        // Queries allowed at once per client address, defaults to qps
+	SubnetQPS   float64 `json:"subnet_qps"`   // Queries per second per client subnet, 0 for no limit
+	SubnetBurst float64 `json:"subnet_burst"` // Queries allowed at once per client subnet, defaults to subnet_qps
+	IPv4Prefix  int     `json:"ipv4_prefix"`  // Subnet prefix length of IPv4 clients, defaults to 24
+	IPv6Prefix  int     `json:"ipv6_prefix"`  // Subnet prefix length of IPv6 clients, defaults to 56
+	Slip        uint32  `json:"slip"`         // Every slip:th limited UDP query gets an empty truncated reply to retry over TCP, 0 drops all
+}
+
+// rateLimitSweep is how often buckets which have refilled are forgotten
+const rateLimitSweep = time.Minute
+
+// tokenBucket holds the tokens of a single client address or subnet
+type tokenBucket struct {
+	tokens  float64
+	last    time.Time
+	limited uint32 // Queries limited, for slipping
+}
+
+// take refills bucket b and takes a token if there is one
+func (b *tokenBucket) take(rate float64, burst float64, now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// full tells if bucket b has refilled by now
+func (b *tokenBucket) full(rate float64, burst float64, now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*rate >= burst
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	cfg     RateLimit
+	v4mask  net.IPMask
+	v6mask  net.IPMask
+	clients map[string]*tokenBucket
+	subnets map[string]*tokenBucket
+	swept   time.Time
+}
+
+func newRateLimiter(cfg RateLimit) (*rateLimiter, error) {
+	if cfg.QPS < 0 || cfg.SubnetQPS < 0 || cfg.Burst < 0 || cfg.SubnetBurst < 0 {
+		return nil, fmt.Errorf(`rate limit: negative rate`)
+	}
+
+	if cfg.Burst == 0 {
+		cfg.Burst = cfg.QPS
+	}
+
+	if cfg.SubnetBurst == 0 {
+		cfg.SubnetBurst = cfg.SubnetQPS
+	}
+
+	// Fractional rates still need room for a whole query
+	if cfg.QPS > 0 && cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+
+	if cfg.SubnetQPS > 0 && cfg.SubnetBurst < 1 {
+		cfg.SubnetBurst = 1
+	}
+
+	if cfg.IPv4Prefix == 0 {
+		cfg.IPv4Prefix = 24
+	}
+
+	if cfg.IPv6Prefix == 0 {
+		cfg.IPv6Prefix = 56
+	}
+
+	if cfg.IPv4Prefix < 0 || cfg.IPv4Prefix > 32 || cfg.IPv6Prefix < 0 || cfg.IPv6Prefix > 128 {
+		return nil, fmt.Errorf(`rate limit: invalid subnet prefix length`)
+	}
+
+	return &rateLimiter{
+		cfg:     cfg,
+		v4mask:  net.CIDRMask(cfg.IPv4Prefix, 32),
+		v6mask:  net.CIDRMask(cfg.IPv6Prefix, 128),
+		clients: make(map[string]*tokenBucket),
+		subnets: make(map[string]*tokenBucket),
+	}, nil
+}
+
+// bucket gets bucket of key k, new buckets are full
+func bucket(m map[string]*tokenBucket, k string, burst float64, now time.Time) *tokenBucket {
+	b, ok := m[k]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		m[k] = b
+	}
+
+	return b
+}
+
+// sweep forgets buckets which have refilled so that the maps don't grow without bound
+func sweep(m map[string]*tokenBucket, rate float64, burst float64, now time.Time) {
+	for k, b := range m {
+		if b.full(rate, burst, now) {
+			delete(m, k)
+		}
+	}
+}
+
+// limit takes a token of client ip and its subnet. slip tells if the limited query should get a truncated reply.
+func (l *rateLimiter) limit(ip net.IP, now time.Time) (limited bool, slip bool) {
+	if ip == nil || (l.cfg.QPS == 0 && l.cfg.SubnetQPS == 0) {
+		return false, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > rateLimitSweep {
+		sweep(l.clients, l.cfg.QPS, l.cfg.Burst, now)
+		sweep(l.subnets, l.cfg.SubnetQPS, l.cfg.SubnetBurst, now)
+		l.swept = now
+	}
+
+	var b *tokenBucket
+
+	if l.cfg.QPS > 0 {
+		b = bucket(l.clients, ip.String(), l.cfg.Burst, now)
+		if !b.take(l.cfg.QPS, l.cfg.Burst, now) {
+			return true, l.slip(b)
+		}
+	}
+
+	if l.cfg.SubnetQPS > 0 {
+		var subnet net.IP
+
+		if ip4 := ip.To4(); ip4 != nil {
+			subnet = ip4.Mask(l.v4mask)
+		} else {
+			subnet = ip.Mask(l.v6mask)
+		}
+
+		b = bucket(l.subnets, subnet.String(), l.cfg.SubnetBurst, now)
+		if !b.take(l.cfg.SubnetQPS, l.cfg.SubnetBurst, now) {
+			return true, l.slip(b)
+		}
+	}
+
+	return false, false
+}
+
+func (l *rateLimiter) slip(b *tokenBucket) bool {
+	b.limited++
+	return l.cfg.Slip > 0 && b.limited%l.cfg.Slip == 0
+}
+
+// rateLimited checks the rate limit of the client group. Limited queries are dropped or get an empty truncated reply
+// so that legitimate clients behind a spoofed address can retry over TCP.
+// TCP queries aren't limited as their client address can't be spoofed.
+func (s *Service) rateLimited(w dns.ResponseWriter, req *dns.Msg, q *query, group clientGroup) bool {
+	if _, udp := w.RemoteAddr().(*net.UDPAddr); !udp {
+		return false
+	}
+
+	l := group.limiter
+	if l == nil {
+		l = s.limiter
+	}
+
+	if l == nil {
+		return false
+	}
+
+	limited, slip := l.limit(q.client, q.start)
+	if !limited {
+		return false
+	}
+
+	q.decision = decisionLimited
+
+	if !slip {
+		return true
+	}
+
+	resp := &dns.Msg{}
+	resp.SetReply(req)
+	resp.Truncated = true
+	q.rcode = resp.Rcode
+
+	err := w.WriteMsg(resp)
+	if err != nil {
+		s.errch <- err
+	}
+
+	return true
+}
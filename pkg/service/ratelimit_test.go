@@ -0,0 +1,238 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+
+	tests := []struct {
+		name  string
+		rate  float64
+		burst float64
+		at    []time.Duration // Time of each take since start
+		want  []bool
+	}{
+		{`burst then empty`, 2, 3, []time.Duration{0, 0, 0, 0}, []bool{true, true, true, false}},
+		{`refills at rate`, 2, 1, []time.Duration{0, 0, 250 * time.Millisecond, 500 * time.Millisecond}, []bool{true, false, false, true}},
+		{`refill capped at burst`, 1, 2, []time.Duration{0, 0, time.Hour, time.Hour, time.Hour}, []bool{true, true, true, true, false}},
+		{`fractional rate`, 0.5, 1, []time.Duration{0, time.Second, 2 * time.Second}, []bool{true, false, true}},
+		{`limited takes still refill`, 1, 1, []time.Duration{0, 500 * time.Millisecond, time.Second}, []bool{true, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &tokenBucket{tokens: tt.burst, last: start}
+
+			for i, d := range tt.at {
+				if got := b.take(tt.rate, tt.burst, start.Add(d)); got != tt.want[i] {
+					t.Fatalf(`take %d at %v: got %v, want %v`, i, d, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTokenBucketFull(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	b := &tokenBucket{tokens: 0, last: start}
+
+	if b.full(1, 2, start.Add(time.Second)) {
+		t.Fatal(`full after refilling half`)
+	}
+
+	if !b.full(1, 2, start.Add(2*time.Second)) {
+		t.Fatal(`not full after refilling all`)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+
+	type request struct {
+		ip      string
+		at      time.Duration
+		limited bool
+		slip    bool
+	}
+
+	tests := []struct {
+		name    string
+		cfg     RateLimit
+		queries []request
+	}{
+		{
+			`disabled`,
+			RateLimit{},
+			[]request{{`192.0.2.1`, 0, false, false}, {`192.0.2.1`, 0, false, false}},
+		},
+		{
+			`per client`,
+			RateLimit{QPS: 1},
+			[]request{
+				{`192.0.2.1`, 0, false, false},
+				{`192.0.2.1`, 0, true, false},
+				{`192.0.2.2`, 0, false, false},
+				{`192.0.2.1`, time.Second, false, false},
+			},
+		},
+		{
+			`per IPv4 subnet`,
+			RateLimit{SubnetQPS: 2},
+			[]request{
+				{`192.0.2.1`, 0, false, false},
+				{`192.0.2.200`, 0, false, false},
+				{`192.0.2.3`, 0, true, false},
+				{`198.51.100.1`, 0, false, false},
+			},
+		},
+		{
+			`per IPv6 subnet`,
+			RateLimit{SubnetQPS: 1, IPv6Prefix: 64},
+			[]request{
+				{`2001:db8::1`, 0, false, false},
+				{`2001:db8::ffff`, 0, true, false},
+				{`2001:db8:0:1::1`, 0, false, false},
+			},
+		},
+		{
+			`client and subnet`,
+			RateLimit{QPS: 2, SubnetQPS: 3},
+			[]request{
+				{`192.0.2.1`, 0, false, false},
+				{`192.0.2.1`, 0, false, false},
+				{`192.0.2.1`, 0, true, false},
+				{`192.0.2.2`, 0, false, false},
+				{`192.0.2.3`, 0, true, false},
+			},
+		},
+		{
+			`every second limited query slips`,
+			RateLimit{QPS: 1, Slip: 2},
+			[]request{
+				{`192.0.2.1`, 0, false, false},
+				{`192.0.2.1`, 0, true, false},
+				{`192.0.2.1`, 0, true, true},
+				{`192.0.2.1`, 0, true, false},
+				{`192.0.2.1`, 0, true, true},
+			},
+		},
+		{
+			`fractional rate`,
+			RateLimit{QPS: 0.1},
+			[]request{
+				{`192.0.2.1`, 0, false, false},
+				{`192.0.2.1`, 5 * time.Second, true, false},
+				{`192.0.2.1`, 10 * time.Second, false, false},
+			},
+		},
+		{
+			`no client address`,
+			RateLimit{QPS: 1},
+			[]request{{``, 0, false, false}, {``, 0, false, false}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := newRateLimiter(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, q := range tt.queries {
+				limited, slip := l.limit(net.ParseIP(q.ip), start.Add(q.at))
+				if limited != q.limited || slip != q.slip {
+					t.Fatalf(`query %d from %s: got limited %v slip %v, want %v %v`, i, q.ip, limited, slip, q.limited, q.slip)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+
+	l, err := newRateLimiter(RateLimit{QPS: 1, SubnetQPS: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.limit(net.ParseIP(`192.0.2.1`), start)
+	l.limit(net.ParseIP(`192.0.2.2`), start)
+
+	if len(l.clients) != 2 || len(l.subnets) != 1 {
+		t.Fatalf(`got %d client and %d subnet buckets`, len(l.clients), len(l.subnets))
+	}
+
+	// Refilled buckets are forgotten on the next sweep
+	l.limit(net.ParseIP(`192.0.2.3`), start.Add(2*rateLimitSweep))
+
+	if len(l.clients) != 1 || len(l.subnets) != 1 {
+		t.Fatalf(`got %d client and %d subnet buckets after sweep`, len(l.clients), len(l.subnets))
+	}
+}
+
+func TestNewRateLimiterInvalid(t *testing.T) {
+	for _, cfg := range []RateLimit{
+		{QPS: -1},
+		{SubnetBurst: -1},
+		{QPS: 1, IPv4Prefix: 33},
+		{QPS: 1, IPv6Prefix: 129},
+		{QPS: 1, IPv4Prefix: -1},
+	} {
+		if _, err := newRateLimiter(cfg); err == nil {
+			t.Errorf(`%+v accepted`, cfg)
+		}
+	}
+}
+
+func TestSlipRetryOverTCP(t *testing.T) {
+	dir := t.TempDir()
+	zone := path.Join(dir, `lan.zone`)
+
+	err := os.WriteFile(zone, []byte("lan. IN SOA ns.lan. hostmaster.lan. 1 3600 600 86400 60\nnas.lan. IN A 192.168.1.2\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestService(t, Config{
+		Local:     &Local{Files: []string{zone}, Path: path.Join(dir, `local.zone`)},
+		RateLimit: &RateLimit{QPS: 1, Slip: 1},
+	})
+
+	addr := serveTest(t, s)
+
+	req := &dns.Msg{}
+	req.SetQuestion(`nas.lan.`, dns.TypeA)
+
+	tests := []struct {
+		network   string
+		truncated bool
+		answers   int
+	}{
+		{`udp`, false, 1},
+		{`udp`, true, 0},
+		{`tcp`, false, 1},
+		{`tcp`, false, 1},
+	}
+
+	for i, tt := range tests {
+		c := &dns.Client{Net: tt.network, Timeout: 2 * time.Second}
+
+		resp, _, err := c.Exchange(req, addr)
+		if err != nil {
+			t.Fatalf(`query %d over %s: %v`, i, tt.network, err)
+		}
+
+		if resp.Truncated != tt.truncated || len(resp.Answer) != tt.answers {
+			t.Fatalf(`query %d over %s: got truncated %v with %d answers, want %v with %d`, i, tt.network, resp.Truncated, len(resp.Answer), tt.truncated, tt.answers)
+		}
+	}
+}
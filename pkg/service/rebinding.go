@@ -0,0 +1,104 @@
+package service
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+)
+
+// Rebinding actions
+const (
+	rebindingBlock = `block` // Whole answer is blocked
+	rebindingDrop  = `drop`  // Only records with non-public addresses are removed
+)
+
+// Rebinding is the configuration of DNS rebinding protection.
+// Forwarded answers of names outside internal domains can't contain private, loopback or link-local addresses,
+// including address hints of SVCB and HTTPS records.
+type Rebinding struct {
+	Action string   `json:"action"` // block (default) or drop
+	Allow  []string `json:"allow"`  // Internal domains, including subdomains. Forward zones are always internal.
+}
+
+func (r *Rebinding) validate() error {
+	switch r.Action {
+	case ``:
+		r.Action = rebindingBlock
+	case rebindingBlock, rebindingDrop:
+	default:
+		return fmt.Errorf(`rebinding: unknown action %q`, r.Action)
+	}
+
+	for i, d := range r.Allow {
+		d = dns.Fqdn(strings.ToLower(strings.TrimSpace(d)))
+
+		if _, ok := dns.IsDomainName(d); !ok {
+			return fmt.Errorf(`rebinding: invalid domain %q`, r.Allow[i])
+		}
+
+		r.Allow[i] = d
+	}
+
+	return nil
+}
+
+// internal tells if name is in an internal domain which can have private addresses
+func (s *Service) internal(name string) bool {
+	name = dns.Fqdn(strings.ToLower(name))
+
+	if zone, _ := s.forwardZones.lookup(name); zone != `` {
+		return true
+	}
+
+	if s.rebinding == nil {
+		return false
+	}
+
+	for _, d := range s.rebinding.Allow {
+		if dns.IsSubDomain(d, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rebound tells if ip of name is a non-public address of a name that's not internal.
+// Always false if protection is disabled.
+func (s *Service) rebound(name string, ip net.IP) bool {
+	if s.rebinding == nil {
+		return false
+	}
+
+	return (isPrivate(ip) || !s.checkIPAddress(ip)) && !s.internal(name)
+}
+
+// answerIPs gets addresses of A and AAAA records and address hints of SVCB and HTTPS records, nil for other records
+func answerIPs(rr dns.RR) []net.IP {
+	switch r := rr.(type) {
+	case *dns.A:
+		return []net.IP{r.A}
+	case *dns.AAAA:
+		return []net.IP{r.AAAA}
+	case *dns.SVCB:
+		return svcbHints(r.Value)
+	case *dns.HTTPS:
+		return svcbHints(r.Value)
+	}
+
+	return nil
+}
+
+func svcbHints(kv []dns.SVCBKeyValue) (l []net.IP) {
+	for _, v := range kv {
+		switch h := v.(type) {
+		case *dns.SVCBIPv4Hint:
+			l = append(l, h.Hint...)
+		case *dns.SVCBIPv6Hint:
+			l = append(l, h.Hint...)
+		}
+	}
+
+	return l
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	"net"
+	"testing"
+)
+
+func TestRebinding(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  string
+		qtype   uint16
+		records []string
+		blocked bool
+		answers int
+	}{
+		{`public address`, rebindingBlock, dns.TypeA, []string{`www.example.com. 60 IN A 93.184.216.34`}, false, 1},
+		{`private address`, rebindingBlock, dns.TypeA, []string{`www.example.com. 60 IN A 192.168.1.1`}, true, 1},
+		{`shared address space`, rebindingBlock, dns.TypeA, []string{`www.example.com. 60 IN A 100.64.0.1`}, true, 1},
+		{`loopback address`, rebindingBlock, dns.TypeA, []string{`www.example.com. 60 IN A 127.0.0.1`}, true, 1},
+		{`unique local address`, rebindingBlock, dns.TypeAAAA, []string{`www.example.com. 60 IN AAAA fd00::1`}, true, 1},
+		{`link-local address`, rebindingBlock, dns.TypeAAAA, []string{`www.example.com. 60 IN AAAA fe80::1`}, true, 1},
+		{`public HTTPS hints`, rebindingBlock, dns.TypeHTTPS, []string{`www.example.com. 60 IN HTTPS 1 . alpn=h2 ipv4hint=93.184.216.34 ipv6hint=2001:db8::1`}, false, 1},
+		{`private HTTPS ipv4hint`, rebindingBlock, dns.TypeHTTPS, []string{`www.example.com. 60 IN HTTPS 1 . ipv4hint=93.184.216.34,10.0.0.1`}, true, 0},
+		{`private SVCB ipv6hint`, rebindingBlock, dns.TypeSVCB, []string{`www.example.com. 60 IN SVCB 1 svc.example.com. ipv6hint=fd00::1`}, true, 0},
+		{`drop private address`, rebindingDrop, dns.TypeA, []string{`www.example.com. 60 IN A 192.168.1.1`, `www.example.com. 60 IN A 93.184.216.34`}, false, 1},
+		{`drop private HTTPS hint`, rebindingDrop, dns.TypeHTTPS, []string{`www.example.com. 60 IN HTTPS 1 . ipv4hint=192.168.1.1`}, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := serveUpstream(t, func(q dns.Question) (l []dns.RR) {
+				for _, r := range tt.records {
+					l = append(l, mustRR(t, r))
+				}
+
+				return l
+			})
+
+			s := newTestService(t, Config{
+				Forwarders: []string{upstream},
+				Rebinding:  &Rebinding{Action: tt.action},
+			})
+
+			resp, q := forward(t, s, `www.example.com.`, tt.qtype)
+
+			if blocked := q.decision == decisionBlocked; blocked != tt.blocked {
+				t.Fatalf(`got blocked %v (%s), want %v`, blocked, q.reason, tt.blocked)
+			}
+
+			if len(resp.Answer) != tt.answers {
+				t.Fatalf(`got answer %v, want %d records`, resp.Answer, tt.answers)
+			}
+
+			// Blocked address answers are the null address of the block mode
+			if tt.blocked && tt.answers > 0 {
+				if ips := answerIPs(resp.Answer[0]); len(ips) != 1 || !ips[0].IsUnspecified() {
+					t.Fatalf(`got blocked answer %v`, resp.Answer)
+				}
+			}
+		})
+	}
+}
+
+func TestRebound(t *testing.T) {
+	r := &Rebinding{Allow: []string{`Lan.Example`}}
+	if err := r.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestService(t, Config{
+		ForwardZones: []ForwardZone{{Domain: `corp.example`, Forwarders: []string{`10.0.0.53:53`}}},
+		Rebinding:    r,
+	})
+
+	tests := []struct {
+		name string
+		ip   string
+		want bool
+	}{
+		{`www.example.com.`, `93.184.216.34`, false},
+		{`www.example.com.`, `192.168.1.1`, true},
+		{`www.example.com.`, `::1`, true},
+		{`lan.example.`, `192.168.1.1`, false},
+		{`NAS.Lan.Example.`, `192.168.1.2`, false},
+		{`notlan.example.`, `192.168.1.1`, true},
+		{`host.corp.example.`, `10.1.1.1`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+` `+tt.ip, func(t *testing.T) {
+			if got := s.rebound(tt.name, net.ParseIP(tt.ip)); got != tt.want {
+				t.Fatalf(`got %v, want %v`, got, tt.want)
+			}
+		})
+	}
+
+	s.rebinding = nil
+
+	if s.rebound(`www.example.com.`, net.ParseIP(`192.168.1.1`)) {
+		t.Fatal(`rebound with protection disabled`)
+	}
+}
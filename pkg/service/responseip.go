@@ -0,0 +1,66 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// Response IP actions
+const (
+	responseIPBlock = `block` // Answer is blocked
+	responseIPAllow = `allow` // Address is trusted, rebinding protection is skipped
+	responseIPLog   = `log`   // Address is logged and checks continue
+)
+
+// ResponseIPRule matches addresses in forwarded A and AAAA answers and address hints of SVCB and HTTPS answers
+type ResponseIPRule struct {
+	Networks []string `json:"networks"` // CIDR or single address
+	Action   string   `json:"action"`   // block, allow or log
+}
+
+type responseIPNet struct {
+	net    *net.IPNet
+	action string
+}
+
+// responseIPPolicy is ordered from the most specific network so that the first match wins
+type responseIPPolicy []responseIPNet
+
+func newResponseIPPolicy(cfg []ResponseIPRule) (p responseIPPolicy, err error) {
+	for _, r := range cfg {
+		switch r.Action {
+		case responseIPBlock, responseIPAllow, responseIPLog:
+		default:
+			return nil, fmt.Errorf(`response IP: unknown action %q`, r.Action)
+		}
+
+		nets, err := parseNets(r.Networks)
+		if err != nil {
+			return nil, fmt.Errorf(`response IP: %w`, err)
+		}
+
+		for _, ipnet := range nets {
+			p = append(p, responseIPNet{net: ipnet, action: r.Action})
+		}
+	}
+
+	sort.SliceStable(p, func(i, j int) bool {
+		a, _ := p[i].net.Mask.Size()
+		b, _ := p[j].net.Mask.Size()
+		return a > b
+	})
+
+	return p, nil
+}
+
+// match gets action of the most specific network containing ip, empty if none
+func (p responseIPPolicy) match(ip net.IP) (action string, n *net.IPNet) {
+	for _, r := range p {
+		if r.net.Contains(ip) {
+			return r.action, r.net
+		}
+	}
+
+	return ``, nil
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"net"
+	"testing"
+)
+
+func TestResponseIPMatch(t *testing.T) {
+	p, err := newResponseIPPolicy([]ResponseIPRule{
+		{Networks: []string{`198.51.100.0/24`, `2001:db8::/32`}, Action: responseIPBlock},
+		{Networks: []string{`198.51.100.7`}, Action: responseIPAllow},
+		{Networks: []string{`198.51.0.0/16`}, Action: responseIPLog},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip     string
+		action string
+		net    string
+	}{
+		{`198.51.100.1`, responseIPBlock, `198.51.100.0/24`},
+		{`198.51.100.7`, responseIPAllow, `198.51.100.7/32`},
+		{`198.51.1.1`, responseIPLog, `198.51.0.0/16`},
+		{`2001:db8::1`, responseIPBlock, `2001:db8::/32`},
+		{`192.0.2.1`, ``, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			action, n := p.match(net.ParseIP(tt.ip))
+
+			if action != tt.action || (n == nil) != (tt.net == ``) || (n != nil && n.String() != tt.net) {
+				t.Fatalf(`got %q %v, want %q %s`, action, n, tt.action, tt.net)
+			}
+		})
+	}
+}
+
+func TestResponseIPAnswer(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []string
+		mode    string
+		blocked bool
+		answer  []string
+	}{
+		{`not matched`, []string{`www.example.com. 60 IN A 192.0.2.1`}, ``, false,
+			[]string{"www.example.com.\t60\tIN\tA\t192.0.2.1"}},
+		{`blocked to null IP`, []string{`www.example.com. 60 IN A 198.51.100.1`}, ``, true,
+			[]string{"www.example.com.\t60\tIN\tA\t0.0.0.0"}},
+		{`blocked to NXDOMAIN`, []string{`www.example.com. 60 IN A 198.51.100.1`}, iface.BlockModeNXDomain, true, nil},
+		{`one blocked address blocks all`, []string{`www.example.com. 60 IN A 192.0.2.1`, `www.example.com. 60 IN A 198.51.100.1`}, ``, true,
+			[]string{"www.example.com.\t60\tIN\tA\t0.0.0.0"}},
+		{`blocked behind CNAME`, []string{`www.example.com. 60 IN CNAME cdn.example.net.`, `cdn.example.net. 60 IN A 198.51.100.1`}, ``, true,
+			[]string{"www.example.com.\t60\tIN\tA\t0.0.0.0"}},
+		{`allowed private address skips rebinding`, []string{`www.example.com. 60 IN A 10.9.9.9`}, ``, false,
+			[]string{"www.example.com.\t60\tIN\tA\t10.9.9.9"}},
+		{`logged private address is still rebinding`, []string{`www.example.com. 60 IN A 10.8.8.8`}, ``, true,
+			[]string{"www.example.com.\t60\tIN\tA\t0.0.0.0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := serveUpstream(t, func(q dns.Question) (l []dns.RR) {
+				for _, r := range tt.records {
+					l = append(l, mustRR(t, r))
+				}
+
+				return l
+			})
+
+			s := newTestService(t, Config{
+				Forwarders: []string{upstream},
+				Blocked:    Blocked{IPv4: `0.0.0.0`, IPv6: `::`, PTR: `invalid.`, Mode: tt.mode},
+				ResponseIP: []ResponseIPRule{
+					{Networks: []string{`198.51.100.0/24`}, Action: responseIPBlock},
+					{Networks: []string{`10.9.9.9`}, Action: responseIPAllow},
+					{Networks: []string{`10.8.0.0/16`}, Action: responseIPLog},
+				},
+				Rebinding: &Rebinding{Action: rebindingBlock},
+			})
+
+			resp, q := forward(t, s, `www.example.com.`, dns.TypeA)
+
+			if blocked := q.decision == decisionBlocked; blocked != tt.blocked {
+				t.Fatalf(`got blocked %v (%s), want %v`, blocked, q.reason, tt.blocked)
+			}
+
+			var answer []string
+			for _, rr := range resp.Answer {
+				answer = append(answer, rr.String())
+			}
+
+			if len(answer) != len(tt.answer) {
+				t.Fatalf(`got answer %q, want %q`, answer, tt.answer)
+			}
+
+			for i := range answer {
+				if answer[i] != tt.answer[i] {
+					t.Fatalf(`got answer %q, want %q`, answer, tt.answer)
+				}
+			}
+
+			if tt.mode == iface.BlockModeNXDomain && resp.Rcode != dns.RcodeNameError {
+				t.Fatalf(`got rcode %s`, dns.RcodeToString[resp.Rcode])
+			}
+		})
+	}
+}
+
+func TestNewResponseIPPolicyInvalid(t *testing.T) {
+	for _, cfg := range [][]ResponseIPRule{
+		{{Networks: []string{`198.51.100.0/24`}, Action: `rewrite`}},
+		{{Networks: []string{`198.51.100.0/33`}, Action: responseIPBlock}},
+		{{Networks: []string{`bogus`}, Action: responseIPLog}},
+	} {
+		if _, err := newResponseIPPolicy(cfg); err == nil {
+			t.Errorf(`%+v accepted`, cfg)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/raspi/torjuja/pkg/db/iface"
+	"github.com/raspi/torjuja/pkg/rpz"
+	"time"
+)
+
+// errDropped is returned when a query is dropped without a reply
+var errDropped = errors.New(`dropped`)
+
+// RPZ precedences
+const (
+	rpzPrecedenceRPZ = `rpz` // Policy zones decide before allow and deny rules
+	rpzPrecedenceDB  = `db`  // Allow and deny rules decide, policy zones apply to names without a rule
+)
+
+// RPZ is the configuration of response policy zones
+type RPZ struct {
+	Zones      []RPZZone `json:"zones"`      // In order of precedence
+	Precedence string    `json:"precedence"` // rpz (default) or db
+}
+
+// RPZZone is a policy zone read from File or transferred from Primary
+type RPZZone struct {
+	Name    string `json:"name"`              // Zone name, for example rpz.example.com
+	File    string `json:"file,omitempty"`    // Zone file
+	Primary string `json:"primary,omitempty"` // Primary server for AXFR, host:port
+	Refresh uint32 `json:"refresh,omitempty"` // Seconds between checking for changes
+}
+
+func (c *RPZ) validate() error {
+	switch c.Precedence {
+	case ``:
+		c.Precedence = rpzPrecedenceRPZ
+	case rpzPrecedenceRPZ, rpzPrecedenceDB:
+	default:
+		return fmt.Errorf(`rpz: unknown precedence %q`, c.Precedence)
+	}
+
+	for i, z := range c.Zones {
+		if _, ok := dns.IsDomainName(z.Name); !ok || z.Name == `` {
+			return fmt.Errorf(`rpz: invalid zone name %q`, z.Name)
+		}
+
+		if (z.File == ``) == (z.Primary == ``) {
+			return fmt.Errorf(`rpz %s: either file or primary is needed`, z.Name)
+		}
+
+		if z.Refresh == 0 {
+			c.Zones[i].Refresh = 60
+		}
+	}
+
+	return nil
+}
+
+// watchRPZ keeps policy zones up to date
+func (s *Service) watchRPZ() {
+	for i, z := range s.rpzConfig.Zones {
+		go func(i int, z RPZZone) {
+			src := rpz.Source{
+				Name:    z.Name,
+				File:    z.File,
+				Primary: z.Primary,
+			}
+
+			rpz.Watch(src, time.Duration(z.Refresh)*time.Second, func(zone *rpz.Zone) {
+				s.logger.Printf(`rpz: loaded %s serial %d`, zone.Name, zone.Serial)
+				s.rpz.Update(i, zone)
+			}, s.errch)
+		}(i, z)
+	}
+}
+
+// rpzApplies tells if policy zones apply to question q under the configured precedence
+func (s *Service) rpzApplies(q dns.Question) bool {
+	if s.rpz == nil {
+		return false
+	}
+
+	if s.rpzConfig.Precedence == rpzPrecedenceRPZ {
+		return true
+	}
+
+	_, ok, err := s.db.Match(questionName(q), dns.Type(q.Qtype).String())
+	if err != nil {
+		s.errch <- err
+	}
+
+	return !ok
+}
+
+// rpzQName gets the QNAME policy deciding question q
+func (s *Service) rpzQName(q dns.Question) (rpz.Policy, bool) {
+	if !s.rpzApplies(q) {
+		return rpz.Policy{}, false
+	}
+
+	return s.rpz.QName(q.Name)
+}
+
+// rpzReply finds policy triggered by forwarded reply to question q: QNAME of CNAME targets, answer IP or NSDNAME.
+// NSDNAME triggers only see name servers the forwarder includes in its reply.
+func (s *Service) rpzReply(q dns.Question, reply *dns.Msg) (rpz.Policy, bool) {
+	if !s.rpzApplies(q) {
+		return rpz.Policy{}, false
+	}
+
+	for _, rr := range reply.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			if p, ok := s.rpz.QName(cname.Target); ok {
+				return p, true
+			}
+		}
+
+		for _, ip := range answerIPs(rr) {
+			if p, ok := s.rpz.IP(ip); ok {
+				return p, true
+			}
+		}
+	}
+
+	for _, rrs := range [][]dns.RR{reply.Answer, reply.Ns} {
+		for _, rr := range rrs {
+			if ns, ok := rr.(*dns.NS); ok {
+				if p, ok := s.rpz.NSDName(ns.Ns); ok {
+					return p, true
+				}
+			}
+		}
+	}
+
+	return rpz.Policy{}, false
+}
+
+// rpzBlockMode is the block mode answering policy p, empty if the policy doesn't map to a block mode
+func rpzBlockMode(p rpz.Policy) string {
+	switch p.Action {
+	case rpz.ActionNXDomain:
+		return iface.BlockModeNXDomain
+	case rpz.ActionNoData:
+		return iface.BlockModeNoData
+	}
+
+	return ``
+}
+
+// rpzRespond fills resp with the answer of policy p to question q. Returns errDropped if the query is dropped.
+func (s *Service) rpzRespond(resp *dns.Msg, q dns.Question, p rpz.Policy, query *query) (*dns.Msg, error) {
+	s.metrics.rpz.Inc(p.Action)
+	s.blockLog(q.Name+` [rpz `+p.String()+`]`, dns.Type(q.Qtype).String())
+
+	query.decision = decisionBlocked
+	query.reason = `rpz ` + p.Trigger + ` ` + p.Match + ` ` + p.Action
+
+	resp.Answer = nil
+	resp.Ns = nil
+
+	switch p.Action {
+	case rpz.ActionDrop:
+		return nil, errDropped
+
+	case rpz.ActionLocal:
+		resp.Rcode = dns.RcodeSuccess
+		resp.Answer = p.Answer(q)
+
+		if len(resp.Answer) == 0 {
+			resp.Ns = append(resp.Ns, s.negativeSOA(q))
+		}
+
+	default:
+		s.blockedAnswer(resp, q, rpzBlockMode(p))
+	}
+
+	return resp, nil
+}
@@ -1,12 +1,17 @@
 package service
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"github.com/alexandrevicenzi/go-sse"
 	"github.com/miekg/dns"
+	"github.com/raspi/torjuja/pkg/audit"
 	"github.com/raspi/torjuja/pkg/db/iface"
+	"github.com/raspi/torjuja/pkg/dnstap"
 	"github.com/raspi/torjuja/pkg/httpapi/frontend"
+	"github.com/raspi/torjuja/pkg/localdata"
+	"github.com/raspi/torjuja/pkg/querylog"
+	"github.com/raspi/torjuja/pkg/rpz"
 	"log"
 	"net"
 	"net/http"
@@ -16,25 +21,53 @@ import (
 	"time"
 )
 
-type Database struct {
-	FileSystem *struct {
-		Path string `json:"path"`
-	} `json:"fs,omitempty"`
-}
-
 type Blocked struct {
-	IPv4 string `json:"ipv4"`
-	IPv6 string `json:"ipv6"`
-	PTR  string `json:"ptr"`
+	IPv4   string            `json:"ipv4"`
+	IPv6   string            `json:"ipv6"`
+	PTR    string            `json:"ptr"`
+	Mode   string            `json:"mode"`             // null-ip (default), nxdomain, nodata, refused or custom
+	Custom map[string]string `json:"custom,omitempty"` // Record type -> record data for mode custom, for example "A": "192.0.2.1"
 }
 
 type Config struct {
-	ApiListen       string   `json:"api"`
-	ListenAddresses []string `json:"listen"`
-	Blocked         Blocked  `json:"blocked"`
-	TTL             uint32   `json:"ttl"`
-	Forwarders      []string `json:"forwarders"`
-	Database        Database `json:"database"`
+	ApiListen         string           `json:"api"`
+	ListenAddresses   []string         `json:"listen"`
+	ACL               []ACL            `json:"acl,omitempty"` // Who may query the listeners, private and loopback addresses if not set
+	Blocked           Blocked          `json:"blocked"`
+	TTL               uint32           `json:"ttl"`
+	Forwarders        []string         `json:"forwarders"`
+	ForwarderStrategy string           `json:"forwarder_strategy"`      // failover (default), round-robin or random
+	ForwardZones      []ForwardZone    `json:"forward_zones,omitempty"` // Domains with forwarders of their own
+	Database          Database         `json:"database"`
+	TLS               *TLS             `json:"tls,omitempty"`
+	Audit             string           `json:"audit,omitempty"` // Audit trail file, defaults to audit.jsonl in the database directory
+	AuditMaxSize      int64            `json:"audit_max_size"`  // Megabytes before the audit trail file is rotated, rotated files are kept
+	HitsFlush         uint32           `json:"hits_flush"`      // Seconds between writing rule hit counters to the database
+	ClientGroups      []ClientGroup    `json:"clients,omitempty"`
+	QueryLog          *QueryLog        `json:"querylog,omitempty"`
+	Dnstap            *Dnstap          `json:"dnstap,omitempty"`
+	Local             *Local           `json:"local,omitempty"`
+	Hosts             *Hosts           `json:"hosts,omitempty"`
+	Rebinding         *Rebinding       `json:"rebinding,omitempty"`    // Rebinding protection, disabled if not set
+	ResponseIP        []ResponseIPRule `json:"response_ip,omitempty"`  // Policy of addresses in forwarded answers
+	CNAMEPolicy       string           `json:"cname_policy,omitempty"` // trust (default) or strict, see CNAME chain policies
+	RPZ               *RPZ             `json:"rpz,omitempty"`          // Response policy zones
+	RateLimit         *RateLimit       `json:"rate_limit,omitempty"`   // Rate limit of clients without a limit of their group, no limit if not set
+	ECS               *ECS             `json:"ecs,omitempty"`          // EDNS Client Subnet policy of forwarded queries, no subnet is sent if not set
+	CaseRandomization bool             `json:"case_randomization"`     // Randomize case of names in forwarded queries (DNS 0x20) and require it echoed
+}
+
+// Local is the configuration of locally answered records
+type Local struct {
+	Files []string `json:"files"` // Zone files
+	Path  string   `json:"path"`  // Zone file of records managed through the API, defaults to local.zone in the database directory
+}
+
+// QueryLog is the configuration of the persistent query log
+type QueryLog struct {
+	Path     string `json:"path"`      // Directory
+	MaxSize  int64  `json:"max_size"`  // Megabytes per file before rotating
+	MaxFiles int    `json:"max_files"` // Rotated files kept
 }
 
 func LoadConfig(p string) (cfg Config, err error) {
@@ -54,6 +87,14 @@ func LoadConfig(p string) (cfg Config, err error) {
 		return cfg, fmt.Errorf(`PTR %q is not FQDN`, cfg.Blocked.PTR)
 	}
 
+	if cfg.Blocked.Mode == `` {
+		cfg.Blocked.Mode = iface.BlockModeNullIP
+	}
+
+	if !iface.ValidBlockMode(cfg.Blocked.Mode) {
+		return cfg, fmt.Errorf(`unknown block mode %q`, cfg.Blocked.Mode)
+	}
+
 	if len(cfg.ListenAddresses) == 0 {
 		return cfg, fmt.Errorf(`no DNS servers`)
 	}
@@ -62,6 +103,14 @@ func LoadConfig(p string) (cfg Config, err error) {
 		return cfg, fmt.Errorf(`no DNS forwarders`)
 	}
 
+	if cfg.Database.Backend == `` {
+		cfg.Database.Backend = defaultBackend
+	}
+
+	if cfg.Database.Backend == defaultBackend && cfg.Database.FileSystem == nil {
+		return cfg, fmt.Errorf(`database: no %s configuration`, defaultBackend)
+	}
+
 	if cfg.Database.FileSystem != nil {
 		fi, err := os.Stat(cfg.Database.FileSystem.Path)
 		if err != nil {
@@ -77,6 +126,71 @@ func LoadConfig(p string) (cfg Config, err error) {
 		}
 	}
 
+	if cfg.HitsFlush == 0 {
+		cfg.HitsFlush = 60
+	}
+
+	if cfg.Audit == `` && cfg.Database.FileSystem != nil {
+		cfg.Audit = path.Join(cfg.Database.FileSystem.Path, `audit.jsonl`)
+	}
+
+	if cfg.Audit != `` && !path.IsAbs(cfg.Audit) {
+		return cfg, fmt.Errorf(`not absolute path: %q`, cfg.Audit)
+	}
+
+	if cfg.AuditMaxSize <= 0 {
+		cfg.AuditMaxSize = 10
+	}
+
+	if cfg.QueryLog != nil {
+		if !path.IsAbs(cfg.QueryLog.Path) {
+			return cfg, fmt.Errorf(`not absolute path: %q`, cfg.QueryLog.Path)
+		}
+
+		if cfg.QueryLog.MaxSize <= 0 {
+			cfg.QueryLog.MaxSize = 10
+		}
+
+		if cfg.QueryLog.MaxFiles <= 0 {
+			cfg.QueryLog.MaxFiles = 10
+		}
+	}
+
+	if cfg.Local != nil {
+		if cfg.Local.Path == `` && cfg.Database.FileSystem != nil {
+			cfg.Local.Path = path.Join(cfg.Database.FileSystem.Path, `local.zone`)
+		}
+
+		if !path.IsAbs(cfg.Local.Path) {
+			return cfg, fmt.Errorf(`not absolute path: %q`, cfg.Local.Path)
+		}
+	}
+
+	if cfg.Rebinding != nil {
+		err = cfg.Rebinding.validate()
+		if err != nil {
+			return cfg, err
+		}
+	}
+
+	if cfg.Hosts != nil {
+		err = cfg.Hosts.validate()
+		if err != nil {
+			return cfg, err
+		}
+	}
+
+	if cfg.Dnstap != nil && cfg.Dnstap.Buffer <= 0 {
+		cfg.Dnstap.Buffer = 1024
+	}
+
+	if cfg.TLS != nil {
+		err = cfg.TLS.validate()
+		if err != nil {
+			return cfg, err
+		}
+	}
+
 	return cfg, nil
 }
 
@@ -84,36 +198,154 @@ type StartupFailureError error
 
 type Service struct {
 	dnsListenServers  []*dns.Server
-	dnsClient         dns.Client // Generic DNS client for forwarder
-	forwarders        []string   // DNS query forwarders
+	dnsClient         dns.Client   // Generic DNS client for forwarder
+	forwardZones      forwardZones // DNS query forwarders by domain
 	errch             chan error
 	httpApiListenAddr string
+	httpApiTLS        *tls.Config // nil for plain HTTP
 	db                iface.Database
 	bogusIPv4         net.IP // A
 	bogusIPv6         net.IP // AAAA
 	bogusTTL          uint32 // Seconds
 	bogusPTR          string // PTR
+	blockedMode       string // Default block mode
+	customAnswers     customAnswers
 	blockLogger       *log.Logger
 	allowLogger       *log.Logger
 	logger            *log.Logger
 	httpfrontend      *frontend.Server
+	stats             *stats
+	metrics           *serviceMetrics
+	querylog          *querylog.Log   // nil if query log is disabled
+	dnstap            *dnstap.Output  // nil if dnstap is disabled
+	local             *localdata.Data // nil if local data is disabled
+	hosts             *Hosts          // nil if hosts and lease files are not read
+	rebinding         *Rebinding      // nil if rebinding protection is disabled
+	responseIP        responseIPPolicy
+	rpz               *rpz.Set // nil if no policy zones
+	rpzConfig         *RPZ
+	cnamePolicy       string
+	clientGroups      clientGroups
+	limiter           *rateLimiter // nil if there is no global rate limit
+	ecs               *ECS         // nil if no client subnet is sent
+	caseRandomization bool
+	hits              *hitCounter   // Rule hits not yet written to db
+	hitsFlush         time.Duration // How often hits are written to db
 }
 
-func New(dnsListenAddresses []string, blocked Blocked, ttl uint32, forwarders []string, db iface.Database, httpApiListen string, errch chan error) (s *Service, err error) {
-	if len(dnsListenAddresses) == 0 {
+func New(cfg Config, db iface.Database, errch chan error) (s *Service, err error) {
+	if len(cfg.ListenAddresses) == 0 {
 		return nil, fmt.Errorf(`no DNS servers`)
 	}
 
-	if len(forwarders) == 0 {
+	if len(cfg.Forwarders) == 0 {
 		return nil, fmt.Errorf(`no DNS forwarders`)
 	}
 
-	if !strings.HasSuffix(blocked.PTR, `.`) {
-		return nil, fmt.Errorf(`PTR %q is not FQDN`, blocked.PTR)
+	if !strings.HasSuffix(cfg.Blocked.PTR, `.`) {
+		return nil, fmt.Errorf(`PTR %q is not FQDN`, cfg.Blocked.PTR)
 	}
 
-	bogusIPv4 := net.ParseIP(blocked.IPv4)
-	bogusIPv6 := net.ParseIP(blocked.IPv6)
+	var auditlog *audit.Log
+
+	if cfg.Audit != `` {
+		auditlog, err = audit.New(cfg.Audit, cfg.AuditMaxSize*1024*1024)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Only backend fs has a default location for the audit trail
+		log.Printf(`warning: no audit trail file set for database backend %s, rule changes aren't recorded`, cfg.Database.Backend)
+	}
+
+	groups, err := newClientGroups(cfg.ClientGroups)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ECS != nil {
+		err = cfg.ECS.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var limiter *rateLimiter
+
+	if cfg.RateLimit != nil {
+		limiter, err = newRateLimiter(*cfg.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	responseIP, err := newResponseIPPolicy(cfg.ResponseIP)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.CNAMEPolicy {
+	case ``:
+		cfg.CNAMEPolicy = cnameTrust
+	case cnameTrust, cnameStrict:
+	default:
+		return nil, fmt.Errorf(`unknown CNAME policy %q`, cfg.CNAMEPolicy)
+	}
+
+	var rpzSet *rpz.Set
+
+	if cfg.RPZ != nil {
+		err = cfg.RPZ.validate()
+		if err != nil {
+			return nil, err
+		}
+
+		rpzSet = rpz.NewSet(len(cfg.RPZ.Zones))
+	}
+
+	zones, err := newForwardZones(cfg.Forwarders, cfg.ForwarderStrategy, cfg.ForwardZones)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Blocked.Mode == `` {
+		cfg.Blocked.Mode = iface.BlockModeNullIP
+	}
+
+	custom, err := newCustomAnswers(cfg.Blocked.Custom)
+	if err != nil {
+		return nil, err
+	}
+
+	var qlog *querylog.Log
+
+	if cfg.QueryLog != nil {
+		qlog, err = querylog.New(cfg.QueryLog.Path, cfg.QueryLog.MaxSize*1024*1024, cfg.QueryLog.MaxFiles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var local *localdata.Data
+
+	if cfg.Local != nil {
+		local, err = localdata.New(cfg.Local.Files, cfg.Local.Path)
+		if err != nil {
+			return nil, err
+		}
+	} else if cfg.Hosts != nil {
+		// Only hosts, records can't be managed through the API
+		local, err = localdata.New(nil, ``)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	st := newStats()
+	m := newServiceMetrics()
+
+	bogusIPv4 := net.ParseIP(cfg.Blocked.IPv4)
+	bogusIPv6 := net.ParseIP(cfg.Blocked.IPv6)
 
 	s = &Service{
 		logger:            log.New(os.Stdout, ``, 0),
@@ -121,28 +353,80 @@ func New(dnsListenAddresses []string, blocked Blocked, ttl uint32, forwarders []
 		allowLogger:       log.New(os.Stdout, `ALLOW: `, 0),
 		bogusIPv4:         bogusIPv4,
 		bogusIPv6:         bogusIPv6,
-		bogusPTR:          blocked.PTR,
-		bogusTTL:          ttl,
+		bogusPTR:          cfg.Blocked.PTR,
+		bogusTTL:          cfg.TTL,
+		blockedMode:       cfg.Blocked.Mode,
+		cnamePolicy:       cfg.CNAMEPolicy,
+		rpz:               rpzSet,
+		rpzConfig:         cfg.RPZ,
+		customAnswers:     custom,
 		dnsClient:         dns.Client{},
-		forwarders:        forwarders,
+		forwardZones:      zones,
 		errch:             errch,
-		httpApiListenAddr: httpApiListen,
+		httpApiListenAddr: cfg.ApiListen,
 		db:                db,
-		httpfrontend:      frontend.New(db),
+		httpfrontend:      frontend.New(db, auditlog, st, m.registry, qlog, local),
+		local:             local,
+		hosts:             cfg.Hosts,
+		rebinding:         cfg.Rebinding,
+		responseIP:        responseIP,
+		stats:             st,
+		metrics:           m,
+		querylog:          qlog,
+		clientGroups:      groups,
+		limiter:           limiter,
+		ecs:               cfg.ECS,
+		caseRandomization: cfg.CaseRandomization,
+		hits:              newHitCounter(),
+		hitsFlush:         time.Duration(cfg.HitsFlush) * time.Second,
 	}
 
-	for _, dnsserver := range dnsListenAddresses {
-		mux := dns.NewServeMux()
-		mux.HandleFunc(`.`, s.handleDNSReq) // Catch-all
+	if s.hitsFlush == 0 {
+		s.hitsFlush = time.Minute
+	}
 
-		dnssrv := &dns.Server{
-			Addr:      dnsserver,
-			Net:       "udp",
-			Handler:   mux,
-			ReusePort: true,
+	if local != nil {
+		st.label = s.clientHostname
+	}
+
+	if cfg.Dnstap != nil {
+		s.dnstap, err = dnstap.New(cfg.Dnstap.Network, cfg.Dnstap.Address, cfg.Dnstap.Identity, `torjuja`, cfg.Dnstap.Buffer, errch)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		s.dnsListenServers = append(s.dnsListenServers, dnssrv)
+	if cfg.TLS != nil {
+		s.httpApiTLS, err = cfg.TLS.config(certificateHosts(cfg.ApiListen))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	acls, err := listenerACLs(cfg.ListenAddresses, cfg.ACL)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dnsserver := range cfg.ListenAddresses {
+		a := acls[dnsserver]
+
+		mux := dns.NewServeMux()
+		mux.HandleFunc(`.`, func(w dns.ResponseWriter, req *dns.Msg) { // Catch-all
+			s.handleDNSReq(w, req, a)
+		})
+
+		// TCP serves large answers and rate limited clients retrying truncated replies
+		for _, network := range []string{`udp`, `tcp`} {
+			dnssrv := &dns.Server{
+				Addr:      dnsserver,
+				Net:       network,
+				Handler:   mux,
+				ReusePort: true,
+			}
+
+			s.dnsListenServers = append(s.dnsListenServers, dnssrv)
+		}
 	}
 
 	return s, nil
@@ -150,7 +434,22 @@ func New(dnsListenAddresses []string, blocked Blocked, ttl uint32, forwarders []
 
 func (s *Service) Listen() error {
 	go func(errs chan error) {
-		if err := http.ListenAndServe(s.httpApiListenAddr, s.httpfrontend.GetRouter()); err != nil {
+		srv := &http.Server{
+			Addr:      s.httpApiListenAddr,
+			Handler:   s.httpfrontend.GetRouter(),
+			TLSConfig: s.httpApiTLS,
+		}
+
+		var err error
+
+		if s.httpApiTLS != nil {
+			// Certificates are already loaded in TLSConfig
+			err = srv.ListenAndServeTLS(``, ``)
+		} else {
+			err = srv.ListenAndServe()
+		}
+
+		if err != nil {
 			errs <- StartupFailureError(err)
 		}
 	}(s.errch)
@@ -163,13 +462,17 @@ func (s *Service) Listen() error {
 		}(server, s.errch)
 	}
 
-	return nil
-}
+	go s.flushHits(s.hitsFlush)
+
+	if s.hosts != nil {
+		s.watchHosts()
+	}
 
-// getForwarder gets DNS forwarder
-func (s *Service) getForwarder() string {
-	// TODO
-	return s.forwarders[0]
+	if s.rpz != nil {
+		s.watchRPZ()
+	}
+
+	return nil
 }
 
 func (s *Service) allowLog(name string, t string) {
@@ -178,76 +481,180 @@ func (s *Service) allowLog(name string, t string) {
 
 func (s *Service) blockLog(name string, t string) {
 	s.blockLogger.Printf(`%s %s`, t, name)
-	s.httpfrontend.SendMessage(`/events/blocked`, sse.SimpleMessage(fmt.Sprintf(`%s %s`, t, name)))
 }
 
 // allowedA checks Service.db for allowed DNS query
 func (s *Service) allowedA(name string) bool {
+	now := time.Now()
 	allowed, err := s.db.AllowedA(name)
+	s.metrics.dbLookupDuration.Observe(time.Since(now).Seconds(), `A`)
 	if err != nil {
 		s.errch <- err
 		return false
 	}
 
+	if allowed {
+		s.hits.add(name, `A`)
+	}
+
 	return allowed
 }
 
 // allowedAAAA checks Service.db for allowed DNS query
 func (s *Service) allowedAAAA(name string) bool {
+	now := time.Now()
 	allowed, err := s.db.AllowedAAAA(name)
+	s.metrics.dbLookupDuration.Observe(time.Since(now).Seconds(), `AAAA`)
 	if err != nil {
 		s.errch <- err
 		return false
 	}
 
+	if allowed {
+		s.hits.add(name, `AAAA`)
+	}
+
 	return allowed
 }
 
 // allowedPTR checks Service.db for allowed DNS query
 func (s *Service) allowedPTR(name string) bool {
+	now := time.Now()
 	allowed, err := s.db.AllowedPTR(name)
+	s.metrics.dbLookupDuration.Observe(time.Since(now).Seconds(), `PTR`)
+	if err != nil {
+		s.errch <- err
+		return false
+	}
+
+	if allowed {
+		s.hits.add(name, `PTR`)
+	}
+
+	return allowed
+}
+
+// allowedType checks Service.db for allowed DNS query of any record type
+func (s *Service) allowedType(name string, t string) bool {
+	now := time.Now()
+	allowed, err := s.db.AllowedType(name, t)
+	s.metrics.dbLookupDuration.Observe(time.Since(now).Seconds(), t)
 	if err != nil {
 		s.errch <- err
 		return false
 	}
 
+	if allowed {
+		s.hits.add(name, t)
+	}
+
 	return allowed
 }
 
 // queryForwarder sends DNS queries to external resolver.
-// Answers are checked against Service.db database
-func (s *Service) queryForwarder(req *dns.Msg) (resp *dns.Msg, dur time.Duration, err error) {
+// Forwarders of the longest matching forward zone are tried in the order of its strategy until one answers.
+// Answers are checked against Service.db database following the CNAME chain policy
+func (s *Service) queryForwarder(req *dns.Msg, q *query) (resp *dns.Msg, dur time.Duration, err error) {
 	resp = &dns.Msg{}
 	resp.SetReply(req)
 	//resp.Rcode = dns.RcodeRefused
 
 	now := time.Now()
 
-	reply, dur, err := s.dnsClient.Exchange(req, s.getForwarder())
+	_, u := s.forwardZones.lookup(req.Question[0].Name)
+
+	var reply *dns.Msg
+
+	for _, fwd := range u.order() {
+		q.upstream = fwd
+		upstream := forwarderAddr(fwd)
+
+		out := s.upstreamQuery(req)
+
+		start := time.Now()
+		s.tap(dnstap.ForwarderQuery, nil, upstream, start, out)
+		reply, dur, err = s.exchange(out, fwd)
+		s.metrics.forwarderRequests.Inc(fwd)
+		if err != nil {
+			s.metrics.forwarderErrors.Inc(fwd)
+			s.errch <- fmt.Errorf(`forwarder %s: %w`, fwd, err)
+			continue
+		}
+
+		s.metrics.forwarderDuration.Observe(dur.Seconds(), fwd)
+		s.tap(dnstap.ForwarderResponse, nil, upstream, start, reply)
+		break
+	}
+
 	if err != nil {
-		s.errch <- err
+		q.decision = decisionError
+		q.reason = err.Error()
 		return nil, time.Now().Sub(now), fmt.Errorf(`forwarder: %w`, err)
 	}
 
+	restoreCase(reply, req.Question[0].Name)
+
+	if p, ok := s.rpzReply(req.Question[0], reply); ok && p.Action != rpz.ActionPassthru {
+		resp, err = s.rpzRespond(resp, req.Question[0], p, q)
+		return resp, time.Now().Sub(now), err
+	}
+
+	chain := newCnameChain(req.Question[0])
+
+answers:
 	for _, a := range reply.Answer {
 		// Process DNS query answers
 		hdr := a.Header()
 
-		switch hdr.Rrtype {
-		case dns.TypeCNAME: // Skip CNAME
-			s.allowLog(hdr.Name+` [forwarder]`, `CNAME`)
-			resp.Answer = append(resp.Answer, a)
-			continue
+		for _, ip := range answerIPs(a) {
+			action, ipnet := s.responseIP.match(ip)
+
+			if action != `` {
+				s.metrics.responseIP.Inc(action)
+			}
+
+			switch action {
+			case responseIPBlock:
+				s.blockLog(hdr.Name+` [response IP `+ip.String()+` in `+ipnet.String()+`]`, dns.Type(hdr.Rrtype).String())
+				q.decision = decisionBlocked
+				q.answerIP = ip
+				q.reason = fmt.Sprintf(`answer %s %s address %s in denied network %s`, dns.Type(hdr.Rrtype).String(), hdr.Name, ip, ipnet)
+				resp.Answer = nil
+				s.blockedAnswer(resp, req.Question[0], s.blockMode(req.Question[0], q))
+				return resp, time.Now().Sub(now), nil
+			case responseIPLog:
+				s.logger.Printf(`response IP: %s %s %s in %s`, dns.Type(hdr.Rrtype).String(), hdr.Name, ip, ipnet)
+			}
+
+			if action != responseIPAllow && s.rebound(hdr.Name, ip) {
+				s.metrics.rebinding.Inc(s.rebinding.Action)
+				s.blockLog(hdr.Name+` [rebinding `+ip.String()+`]`, dns.Type(hdr.Rrtype).String())
+
+				if s.rebinding.Action == rebindingDrop {
+					continue answers
+				}
+
+				q.decision = decisionBlocked
+				q.answerIP = ip
+				q.reason = fmt.Sprintf(`answer %s %s has non-public address %s`, dns.Type(hdr.Rrtype).String(), hdr.Name, ip)
+				resp.Answer = nil
+				s.blockedAnswer(resp, req.Question[0], s.blockMode(req.Question[0], q))
+				return resp, time.Now().Sub(now), nil
+			}
+		}
+
+		// Allowed by the CNAME chain policy?
+		if allowed, reason := s.checkHop(chain, a); !allowed {
+			s.blockLog(hdr.Name+` [forwarder]`, dns.Type(hdr.Rrtype).String())
+			q.decision = decisionBlocked
+			q.reason = reason
+			resp.Answer = nil
+			s.blockedAnswer(resp, req.Question[0], s.blockMode(req.Question[0], q))
+			return resp, time.Now().Sub(now), nil
 		}
 
-		// Allowed?
-		if !s.checkAllowed(dns.Question{
-			Name:   hdr.Name,
-			Qtype:  hdr.Rrtype,
-			Qclass: hdr.Class,
-		}) {
-			s.blockLog(hdr.Name+` [forwarder]`, dns.TypeToString[hdr.Rrtype])
-			return nil, time.Now().Sub(now), fmt.Errorf(`forwarder: not allowed %q`, hdr.Name)
+		if hdr.Rrtype == dns.TypeCNAME {
+			s.allowLog(hdr.Name+` [forwarder]`, `CNAME`)
 		}
 
 		resp.Answer = append(resp.Answer, a)
@@ -257,7 +664,7 @@ func (s *Service) queryForwarder(req *dns.Msg) (resp *dns.Msg, dur time.Duration
 }
 
 // checkDnsRequest queries database Service.db for allowed DNS query
-func (s *Service) checkDnsRequest(req *dns.Msg) (resp *dns.Msg, dur time.Duration, err error) {
+func (s *Service) checkDnsRequest(req *dns.Msg, query *query) (resp *dns.Msg, dur time.Duration, err error) {
 	now := time.Now()
 
 	resp = &dns.Msg{}
@@ -267,77 +674,43 @@ func (s *Service) checkDnsRequest(req *dns.Msg) (resp *dns.Msg, dur time.Duratio
 	for _, q := range req.Question {
 		// Process DNS query questions
 
-		if s.checkAllowed(q) {
+		allowed, rule := s.checkAllowed(q)
+		if allowed {
 			// allowed, forward to a forwarder
-			s.allowLog(q.Name, dns.TypeToString[q.Qtype])
-			return s.queryForwarder(&dns.Msg{
+			s.allowLog(q.Name, dns.Type(q.Qtype).String())
+			query.decision = decisionAllowed
+			query.rule = rule
+			// Query is rebuilt so that nothing from the client, such as its EDNS options, is forwarded
+			fwd := &dns.Msg{
 				MsgHdr: dns.MsgHdr{
 					Id:               resp.Id,
 					RecursionDesired: true,
 				},
 				Question: []dns.Question{q},
-			})
-		}
-
-		s.blockLog(q.Name, dns.TypeToString[q.Qtype])
-
-		hdr := dns.RR_Header{
-			Name:   q.Name,
-			Rrtype: q.Qtype,
-			Class:  q.Qclass,
-			Ttl:    s.bogusTTL,
-		}
-
-		// Generate blocked answer
-		switch q.Qtype {
-		case dns.TypeA:
-			resp.Answer = append(resp.Answer, &dns.A{
-				Hdr: hdr,
-				A:   s.bogusIPv4,
-			})
-
-			resp.Rcode = dns.RcodeSuccess
-
-		case dns.TypeAAAA:
-			resp.Answer = append(resp.Answer, &dns.AAAA{
-				Hdr:  hdr,
-				AAAA: s.bogusIPv6,
-			})
-
-			resp.Rcode = dns.RcodeSuccess
-
-		case dns.TypePTR:
-			addr := net.ParseIP(arpaPTRToString(q.Name))
-
-			if !s.checkIPAddress(addr) {
-				continue
 			}
 
-			resp.Answer = append(resp.Answer, &dns.PTR{
-				Hdr: hdr,
-				Ptr: s.bogusPTR,
-			})
+			s.setECS(fwd, query.client)
 
-			resp.Rcode = dns.RcodeSuccess
+			return s.queryForwarder(fwd, query)
+		}
 
-		case dns.TypeMX:
-			resp.Answer = append(resp.Answer, &dns.MX{
-				Hdr:        hdr,
-				Preference: 0,
-				Mx:         "spam.mail." + s.bogusPTR,
-			})
+		if p, ok := s.rpzQName(q); ok {
+			query.rule = rule
+			resp, err = s.rpzRespond(resp, q, p, query)
+			return resp, time.Now().Sub(now), err
+		}
 
-			resp.Rcode = dns.RcodeSuccess
+		s.blockLog(q.Name, dns.Type(q.Qtype).String())
+		query.reason = `not allowed`
+		query.rule = s.deniedBy(q)
 
-		case dns.TypeCNAME:
-			s.logger.Printf(`cname: %q`, req.Question[0].Name)
-			return s.queryForwarder(req)
-		} // /switch
-	} // /for
+		s.blockedAnswer(resp, q, s.blockMode(q, query))
+	}
 
 	return resp, time.Now().Sub(now), nil
 }
 
+// checkIPAddress tells if addr is a public address
 func (s *Service) checkIPAddress(addr net.IP) bool {
 	if addr.IsMulticast() {
 		return false
@@ -363,41 +736,140 @@ func (s *Service) checkIPAddress(addr net.IP) bool {
 
 }
 
-func (s *Service) checkAllowed(q dns.Question) bool {
-	name := strings.ToLower(strings.TrimRight(q.Name, `.`))
+// metaTypes are query types that never get an answer from the resolver
+var metaTypes = map[uint16]bool{
+	dns.TypeANY:   true,
+	dns.TypeAXFR:  true,
+	dns.TypeIXFR:  true,
+	dns.TypeMAILA: true,
+	dns.TypeMAILB: true,
+	dns.TypeOPT:   true,
+	dns.TypeTSIG:  true,
+	dns.TypeTKEY:  true,
+}
+
+// checkAllowed checks if DNS question is allowed. rule describes what allowed the question.
+func (s *Service) checkAllowed(q dns.Question) (allowed bool, rule string) {
+	if p, ok := s.rpzQName(q); ok {
+		return p.Action == rpz.ActionPassthru, `rpz ` + p.String()
+	}
+
+	name := questionName(q)
+	t := dns.Type(q.Qtype).String()
 
 	switch q.Qtype {
 	case dns.TypeA:
-		return s.allowedA(name)
+		return s.allowedA(name), t + ` ` + name
 	case dns.TypeAAAA:
-		return s.allowedAAAA(name)
+		return s.allowedAAAA(name), t + ` ` + name
 	case dns.TypePTR:
-		name = arpaPTRToString(name)
 		addr := net.ParseIP(name)
 
-		if !s.checkIPAddress(addr) {
-			return false
+		if s.internal(q.Name) {
+			return true, t + ` internal domain`
 		}
 
-		return true
+		if s.allowedPTR(name) {
+			return true, t + ` ` + name
+		}
 
-		// TODO
-		return s.allowedPTR(name)
+		return s.checkIPAddress(addr), t + ` public address`
 	case dns.TypeCNAME, dns.TypeNS, dns.TypeSOA:
-		return true
+		return true, t + ` always allowed`
 	default:
-		return false
+		if metaTypes[q.Qtype] {
+			return false, ``
+		}
+
+		return s.allowedType(name, t), t + ` ` + name
+	}
+}
+
+// deniedBy describes the deny rule matching question q, empty if no rule allows or denies it
+func (s *Service) deniedBy(q dns.Question) string {
+	t := dns.Type(q.Qtype).String()
+
+	r, ok, err := s.db.Match(questionName(q), t)
+	if err != nil {
+		s.errch <- err
+		return ``
+	}
+
+	if !ok || r.Action != iface.ActionDeny {
+		return ``
+	}
+
+	return iface.ActionDeny + ` ` + t + ` ` + r.Name
+}
+
+// questionName gets name that rules of question q are stored by.
+// It's the address for PTR questions and lower case name without trailing dot for others.
+func questionName(q dns.Question) string {
+	name := strings.ToLower(strings.TrimRight(q.Name, `.`))
+
+	if q.Qtype == dns.TypePTR {
+		return arpaPTRToString(name)
+	}
+
+	return name
+}
+
+// negativeSOA is the authority record of synthesized negative answers.
+// Its minimum TTL tells resolvers how long to cache the negative answer (RFC 2308).
+func (s *Service) negativeSOA(q dns.Question) *dns.SOA {
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   q.Name,
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    s.bogusTTL,
+		},
+		Ns:      s.bogusPTR,
+		Mbox:    `hostmaster.` + s.bogusPTR,
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  s.bogusTTL,
 	}
 }
 
-// handleDNSReq handles all DNS requests and forwards them to resolver Service.checkDnsRequest
-func (s *Service) handleDNSReq(w dns.ResponseWriter, req *dns.Msg) {
-	reply, _, err := s.checkDnsRequest(req)
+// handleDNSReq handles all DNS requests of a listener with access control list a and forwards them to resolver Service.checkDnsRequest
+func (s *Service) handleDNSReq(w dns.ResponseWriter, req *dns.Msg, a *acl) {
+	q := newQuery(w.RemoteAddr(), req)
+	group := s.clientGroups.lookup(q.client)
+	q.group = group.name
+	q.blockMode = group.blockMode
+	q.hostname = s.clientHostname(q.clientString())
+	defer s.record(q)
+
+	if s.denied(w, req, q, a) {
+		return
+	}
+
+	if s.rateLimited(w, req, q, group) {
+		return
+	}
+
+	s.tap(dnstap.ClientQuery, w.RemoteAddr(), w.LocalAddr(), q.start, req)
+
+	reply, err := s.answerLocal(req, q)
+	if reply == nil && err == nil {
+		reply, _, err = s.checkDnsRequest(req, q)
+	}
+
+	if err == errDropped {
+		return
+	}
+
 	if err != nil {
 		s.errch <- err
 		return
 	}
 
+	q.rcode = reply.Rcode
+	s.tap(dnstap.ClientResponse, w.RemoteAddr(), w.LocalAddr(), q.start, reply)
+
 	err = w.WriteMsg(reply)
 	if err != nil {
 		s.errch <- err
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	"github.com/raspi/torjuja/pkg/db/memdb"
+	"io"
+	"log"
+	"net"
+	"testing"
+)
+
+// newTestService creates a service with an in-memory database. Missing required settings of cfg are filled in.
+func newTestService(t *testing.T, cfg Config) *Service {
+	t.Helper()
+
+	if len(cfg.ListenAddresses) == 0 {
+		cfg.ListenAddresses = []string{`127.0.0.1:0`}
+	}
+
+	if len(cfg.Forwarders) == 0 {
+		cfg.Forwarders = []string{`127.0.0.1:1`}
+	}
+
+	if cfg.Blocked.PTR == `` {
+		cfg.Blocked = Blocked{IPv4: `0.0.0.0`, IPv6: `::`, PTR: `invalid.`}
+	}
+
+	if cfg.TTL == 0 {
+		cfg.TTL = 60
+	}
+
+	s, err := New(cfg, memdb.New(), make(chan error, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quiet := log.New(io.Discard, ``, 0)
+	s.logger = quiet
+	s.blockLogger = quiet
+	s.allowLogger = quiet
+
+	return s
+}
+
+// serveTest starts the UDP and TCP servers of the first listen address of s on the same free port
+func serveTest(t *testing.T, s *Service) (addr string) {
+	t.Helper()
+
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr = l.Addr().String()
+
+	pc, err := net.ListenPacket(`udp`, addr)
+	if err != nil {
+		l.Close()
+		t.Fatal(err)
+	}
+
+	for _, srv := range s.dnsListenServers[:2] {
+		srv := srv
+
+		switch srv.Net {
+		case `udp`:
+			srv.PacketConn = pc
+		case `tcp`:
+			srv.Listener = l
+		}
+
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+
+		go func() {
+			_ = srv.ActivateAndServe()
+		}()
+
+		<-started
+
+		t.Cleanup(func() { _ = srv.Shutdown() })
+	}
+
+	return addr
+}
+
+// serveUpstream starts a forwarder on a free UDP port answering every question with records of answer
+func serveUpstream(t *testing.T, answer func(q dns.Question) []dns.RR) (addr string) {
+	t.Helper()
+
+	return serveUpstreamHandler(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		resp.Answer = answer(req.Question[0])
+		_ = w.WriteMsg(resp)
+	}))
+}
+
+// serveUpstreamHandler starts a forwarder on a free UDP port served by handler
+func serveUpstreamHandler(t *testing.T, handler dns.Handler) (addr string) {
+	t.Helper()
+
+	pc, err := net.ListenPacket(`udp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+
+	srv := &dns.Server{
+		PacketConn:        pc,
+		NotifyStartedFunc: func() { close(started) },
+		Handler:           handler,
+	}
+
+	go func() {
+		_ = srv.ActivateAndServe()
+	}()
+
+	<-started
+
+	t.Cleanup(func() { _ = srv.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
+
+// mustRR parses record s in zone file format
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rr
+}
+
+// forward sends question of name and type qtype through the forwarding path of s
+func forward(t *testing.T, s *Service, name string, qtype uint16) (*dns.Msg, *query) {
+	t.Helper()
+
+	req := &dns.Msg{}
+	req.SetQuestion(name, qtype)
+
+	q := newQuery(&net.UDPAddr{IP: net.ParseIP(`192.168.1.10`)}, req)
+	q.decision = decisionAllowed
+
+	resp, _, err := s.queryForwarder(req, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, q
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	"github.com/raspi/torjuja/pkg/httpapi/frontend"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	statsTopN          = 10    // Entries in top lists
+	statsMaxKeys       = 10000 // Unique names/clients counted per bucket
+	statsLatencySample = 1000  // Latency samples kept per bucket
+)
+
+// statsBucket holds counters of a single minute or hour
+type statsBucket struct {
+	start     time.Time
+	total     uint64
+	allowed   uint64
+	blocked   uint64
+	dropped   uint64 // Rate limited without reply
+	slipped   uint64 // Rate limited with truncated reply
+	names     map[string]uint64
+	blockedN  map[string]uint64 // Blocked names
+	limitedN  map[string]uint64 // Rate limited clients
+	clients   map[string]uint64
+	qtypes    map[string]uint64
+	latencies []time.Duration
+}
+
+func newStatsBucket(start time.Time) *statsBucket {
+	return &statsBucket{
+		start:    start,
+		names:    make(map[string]uint64),
+		blockedN: make(map[string]uint64),
+		limitedN: make(map[string]uint64),
+		clients:  make(map[string]uint64),
+		qtypes:   make(map[string]uint64),
+	}
+}
+
+func (b *statsBucket) add(q *query, latency time.Duration) {
+	b.total++
+
+	switch q.decision {
+	case decisionAllowed:
+		b.allowed++
+	case decisionBlocked:
+		b.blocked++
+		inc(b.blockedN, q.name)
+	case decisionLimited:
+		if q.rcode < 0 {
+			b.dropped++
+		} else {
+			b.slipped++
+		}
+
+		inc(b.limitedN, q.clientString())
+	}
+
+	inc(b.names, q.name)
+	inc(b.clients, q.clientString())
+	inc(b.qtypes, dns.Type(q.qtype).String())
+
+	if len(b.latencies) < statsLatencySample {
+		b.latencies = append(b.latencies, latency)
+	} else {
+		// Keep sample representative by replacing a pseudo-random old value
+		b.latencies[int(b.total)%statsLatencySample] = latency
+	}
+}
+
+// inc increments counter k unless the map is already full
+func inc(m map[string]uint64, k string) {
+	if _, ok := m[k]; !ok && len(m) >= statsMaxKeys {
+		return
+	}
+
+	m[k]++
+}
+
+// statsRing is a fixed number of consecutive buckets of same length
+type statsRing struct {
+	size    time.Duration
+	buckets []*statsBucket
+}
+
+func (r *statsRing) add(now time.Time, q *query, latency time.Duration) {
+	start := now.Truncate(r.size)
+	idx := int(start.Unix()/int64(r.size.Seconds())) % len(r.buckets)
+
+	b := r.buckets[idx]
+	if b == nil || !b.start.Equal(start) {
+		b = newStatsBucket(start)
+		r.buckets[idx] = b
+	}
+
+	b.add(q, latency)
+}
+
+// since returns buckets that started after t
+func (r *statsRing) since(t time.Time) (l []*statsBucket) {
+	for _, b := range r.buckets {
+		if b != nil && !b.start.Before(t) {
+			l = append(l, b)
+		}
+	}
+
+	return l
+}
+
+// stats aggregates finished queries over rolling windows.
+// Minute buckets are used for the last hour and hour buckets for anything longer.
+type stats struct {
+	mu      sync.Mutex
+	minutes statsRing
+	hours   statsRing
+	label   func(client string) string // Name of client address, nil if clients are not named
+}
+
+func newStats() *stats {
+	return &stats{
+		minutes: statsRing{size: time.Minute, buckets: make([]*statsBucket, 60)},
+		hours:   statsRing{size: time.Hour, buckets: make([]*statsBucket, 7*24)},
+	}
+}
+
+func (s *stats) add(q *query, latency time.Duration) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.minutes.add(now, q, latency)
+	s.hours.add(now, q, latency)
+}
+
+// Stats implements frontend.StatsProvider
+func (s *stats) Stats(window time.Duration) frontend.StatsDTO {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buckets []*statsBucket
+
+	if window <= time.Hour {
+		buckets = s.minutes.since(now.Add(-window).Truncate(time.Minute))
+	} else {
+		buckets = s.hours.since(now.Add(-window).Truncate(time.Hour))
+	}
+
+	var dto frontend.StatsDTO
+
+	names := make(map[string]uint64)
+	blocked := make(map[string]uint64)
+	clients := make(map[string]uint64)
+	limited := make(map[string]uint64)
+	qtypes := make(map[string]uint64)
+	var latencies []time.Duration
+
+	for _, b := range buckets {
+		dto.Total += b.total
+		dto.Allowed += b.allowed
+		dto.Blocked += b.blocked
+		dto.Dropped += b.dropped
+		dto.Slipped += b.slipped
+
+		merge(names, b.names)
+		merge(blocked, b.blockedN)
+		merge(clients, b.clients)
+		merge(limited, b.limitedN)
+		merge(qtypes, b.qtypes)
+
+		latencies = append(latencies, b.latencies...)
+	}
+
+	dto.TopQueried = top(names, statsTopN)
+	dto.TopBlocked = top(blocked, statsTopN)
+	dto.TopClients = top(clients, statsTopN)
+	dto.TopLimited = top(limited, statsTopN)
+
+	if s.label != nil {
+		for i := range dto.TopClients {
+			dto.TopClients[i].Label = s.label(dto.TopClients[i].Name)
+		}
+
+		for i := range dto.TopLimited {
+			dto.TopLimited[i].Label = s.label(dto.TopLimited[i].Name)
+		}
+	}
+	dto.QueryTypes = top(qtypes, 0)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	dto.LatencyP50 = percentile(latencies, 50)
+	dto.LatencyP90 = percentile(latencies, 90)
+	dto.LatencyP99 = percentile(latencies, 99)
+
+	return dto
+}
+
+func merge(dst, src map[string]uint64) {
+	for k, v := range src {
+		dst[k] += v
+	}
+}
+
+// top returns n largest counters, all if n is 0
+func top(m map[string]uint64, n int) []frontend.CountDTO {
+	l := make([]frontend.CountDTO, 0, len(m))
+
+	for k, v := range m {
+		l = append(l, frontend.CountDTO{Name: k, Count: v})
+	}
+
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Count == l[j].Count {
+			return l[i].Name < l[j].Name
+		}
+
+		return l[i].Count > l[j].Count
+	})
+
+	if n > 0 && len(l) > n {
+		l = l[:n]
+	}
+
+	return l
+}
+
+// percentile returns p:th percentile in milliseconds from sorted list
+func percentile(sorted []time.Duration, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	idx := (len(sorted) - 1) * p / 100
+
+	return float64(sorted[idx].Microseconds()) / 1000
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	"net"
+	"sort"
+	"testing"
+	"time"
+)
+
+// statsQuery creates a finished query of name from client with decision
+func statsQuery(name string, client string, decision string) *query {
+	req := &dns.Msg{}
+	req.SetQuestion(name, dns.TypeA)
+
+	q := newQuery(&net.UDPAddr{IP: net.ParseIP(client)}, req)
+	q.decision = decision
+
+	return q
+}
+
+func TestStatsRingRollover(t *testing.T) {
+	start := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		adds  []time.Duration // Offsets from start of added queries
+		since time.Duration   // Offset from start of since
+		want  []uint64        // Totals of returned buckets, oldest first
+	}{
+		{`same minute`, []time.Duration{0, 59 * time.Second}, 0, []uint64{2}},
+		{`consecutive minutes`, []time.Duration{0, time.Minute, time.Minute + time.Second}, 0, []uint64{1, 2}},
+		{`since skips older buckets`, []time.Duration{0, time.Minute, 2 * time.Minute}, time.Minute, []uint64{1, 1}},
+		{`last slot before wrapping`, []time.Duration{0, 59 * time.Minute}, 0, []uint64{1, 1}},
+		{`wrapped bucket is reset`, []time.Duration{0, 0, time.Hour}, 0, []uint64{1}},
+		{`wrapped bucket is reset mid ring`, []time.Duration{0, 30 * time.Minute, 90 * time.Minute}, 0, []uint64{1, 1}},
+		{`day later`, []time.Duration{0, 24 * time.Hour}, 0, []uint64{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newStats().minutes
+
+			for i, d := range tt.adds {
+				r.add(start.Add(d), statsQuery(`example.com.`, `192.0.2.1`, decisionAllowed), time.Duration(i)*time.Millisecond)
+			}
+
+			buckets := r.since(start.Add(tt.since))
+
+			// Ring order isn't time order once it has wrapped
+			sort.Slice(buckets, func(i, j int) bool { return buckets[i].start.Before(buckets[j].start) })
+
+			var got []uint64
+			for _, b := range buckets {
+				got = append(got, b.total)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf(`got totals %v, want %v`, got, tt.want)
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf(`got totals %v, want %v`, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStatsHourRing(t *testing.T) {
+	start := time.Date(2021, 5, 1, 12, 30, 0, 0, time.UTC)
+	r := newStats().hours
+
+	r.add(start, statsQuery(`example.com.`, `192.0.2.1`, decisionAllowed), 0)
+	r.add(start.Add(29*time.Minute), statsQuery(`example.com.`, `192.0.2.1`, decisionAllowed), 0)
+	r.add(start.Add(30*time.Minute), statsQuery(`example.com.`, `192.0.2.1`, decisionAllowed), 0)
+
+	// A week later the slot of the first hour is reused
+	r.add(start.Add(7*24*time.Hour), statsQuery(`example.com.`, `192.0.2.1`, decisionAllowed), 0)
+
+	if l := r.since(start.Truncate(time.Hour)); len(l) != 2 {
+		t.Fatalf(`got %d buckets, want 2`, len(l))
+	}
+
+	if l := r.since(start.Add(7 * 24 * time.Hour).Truncate(time.Hour)); len(l) != 1 || l[0].total != 1 {
+		t.Fatalf(`got %v after a week`, l)
+	}
+}
+
+func TestStatsBucket(t *testing.T) {
+	b := newStatsBucket(time.Time{})
+
+	b.add(statsQuery(`example.com.`, `192.0.2.1`, decisionAllowed), time.Millisecond)
+	b.add(statsQuery(`ads.example.com.`, `192.0.2.1`, decisionBlocked), 2*time.Millisecond)
+	b.add(statsQuery(`ads.example.com.`, `192.0.2.2`, decisionBlocked), 3*time.Millisecond)
+
+	limited := statsQuery(`example.com.`, `192.0.2.3`, decisionLimited)
+	limited.rcode = -1
+	b.add(limited, 0)
+
+	slipped := statsQuery(`example.com.`, `192.0.2.3`, decisionLimited)
+	slipped.rcode = dns.RcodeSuccess
+	b.add(slipped, 0)
+
+	if b.total != 5 || b.allowed != 1 || b.blocked != 2 || b.dropped != 1 || b.slipped != 1 {
+		t.Fatalf(`got total %d allowed %d blocked %d dropped %d slipped %d`, b.total, b.allowed, b.blocked, b.dropped, b.slipped)
+	}
+
+	if b.blockedN[`ads.example.com`] != 2 || b.limitedN[`192.0.2.3`] != 2 || b.clients[`192.0.2.1`] != 2 || b.qtypes[`A`] != 5 {
+		t.Fatalf(`got blocked %v limited %v clients %v types %v`, b.blockedN, b.limitedN, b.clients, b.qtypes)
+	}
+
+	if got := top(b.names, 1); len(got) != 1 || got[0].Name != `example.com` || got[0].Count != 3 {
+		t.Fatalf(`got top %v`, got)
+	}
+}
+
+func TestStatsMaxKeys(t *testing.T) {
+	m := make(map[string]uint64)
+
+	for i := 0; i < statsMaxKeys; i++ {
+		inc(m, time.Duration(i).String())
+	}
+
+	inc(m, `new`)
+	inc(m, `0s`)
+
+	if len(m) != statsMaxKeys || m[`0s`] != 2 {
+		t.Fatalf(`got %d keys, 0s counted %d times`, len(m), m[`0s`])
+	}
+}
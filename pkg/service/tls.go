@@ -0,0 +1,248 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"time"
+)
+
+// TLS is the configuration of HTTPS for the API and frontend listener
+type TLS struct {
+	Certificate string `json:"cert"`                  // PEM certificate file
+	Key         string `json:"key"`                   // PEM private key file
+	MinVersion  string `json:"min_version"`           // 1.2 or 1.3
+	ClientCA    string `json:"client_ca,omitempty"`   // PEM CA bundle for client certificates
+	ClientAuth  string `json:"client_auth,omitempty"` // none, request or require
+	Generate    bool   `json:"generate"`              // Generate self-signed certificate if missing
+}
+
+var tlsVersions = map[string]uint16{
+	`1.2`: tls.VersionTLS12,
+	`1.3`: tls.VersionTLS13,
+}
+
+var tlsClientAuths = map[string]tls.ClientAuthType{
+	``:        tls.NoClientCert,
+	`none`:    tls.NoClientCert,
+	`request`: tls.VerifyClientCertIfGiven,
+	`require`: tls.RequireAndVerifyClientCert,
+}
+
+func (t TLS) validate() error {
+	if t.Certificate == `` || t.Key == `` {
+		return fmt.Errorf(`tls: cert and key are required`)
+	}
+
+	if !path.IsAbs(t.Certificate) {
+		return fmt.Errorf(`tls: not absolute path: %q`, t.Certificate)
+	}
+
+	if !path.IsAbs(t.Key) {
+		return fmt.Errorf(`tls: not absolute path: %q`, t.Key)
+	}
+
+	if t.MinVersion != `` {
+		if _, ok := tlsVersions[t.MinVersion]; !ok {
+			return fmt.Errorf(`tls: unknown minimum version %q`, t.MinVersion)
+		}
+	}
+
+	ca, ok := tlsClientAuths[t.ClientAuth]
+	if !ok {
+		return fmt.Errorf(`tls: unknown client auth %q`, t.ClientAuth)
+	}
+
+	if ca != tls.NoClientCert && t.ClientCA == `` {
+		return fmt.Errorf(`tls: client auth %q requires client_ca`, t.ClientAuth)
+	}
+
+	return nil
+}
+
+// config loads certificates from disk and builds *tls.Config for the HTTP server.
+// Self-signed certificate is generated on first start if TLS.Generate is set, an existing key is reused.
+func (t TLS) config(hosts []string) (*tls.Config, error) {
+	if t.Generate {
+		_, err := os.Stat(t.Certificate)
+		if errors.Is(err, os.ErrNotExist) {
+			err = generateCertificate(t.Certificate, t.Key, hosts)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf(`tls: %w`, err)
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.Certificate, t.Key)
+	if err != nil {
+		return nil, fmt.Errorf(`tls: %w`, err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		ClientAuth:   tlsClientAuths[t.ClientAuth],
+	}
+
+	if t.MinVersion != `` {
+		cfg.MinVersion = tlsVersions[t.MinVersion]
+	}
+
+	if t.ClientCA != `` {
+		b, err := os.ReadFile(t.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf(`tls: %w`, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf(`tls: no certificates found in %q`, t.ClientCA)
+		}
+
+		cfg.ClientCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// generateCertificate writes a self-signed certificate valid for given host names and IP addresses.
+// Existing key is reused, otherwise an ECDSA key is generated and written too.
+func generateCertificate(certPath, keyPath string, hosts []string) error {
+	key, err := readKey(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err = generateKey(keyPath)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{`torjuja`}, CommonName: `torjuja`},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else if h != `` {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	if _, ok := key.Public().(*rsa.PublicKey); ok {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, key.Public(), key)
+	if err != nil {
+		return err
+	}
+
+	return writePEM(certPath, `CERTIFICATE`, der, 0644)
+}
+
+// generateKey writes a new ECDSA private key to p
+func generateKey(p string) (crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writePEM(p, `PRIVATE KEY`, der, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// readKey reads PEM encoded PKCS #8, EC or PKCS #1 private key from p
+func readKey(p string) (crypto.Signer, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf(`no PEM data found in %q`, p)
+	}
+
+	var key interface{}
+
+	switch block.Type {
+	case `EC PRIVATE KEY`:
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case `RSA PRIVATE KEY`:
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf(`%q: %w`, p, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf(`%q: unsupported private key`, p)
+	}
+
+	return signer, nil
+}
+
+func writePEM(p string, t string, b []byte, perm os.FileMode) error {
+	fh, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	return pem.Encode(fh, &pem.Block{Type: t, Bytes: b})
+}
+
+// certificateHosts lists names the generated certificate is valid for
+func certificateHosts(listen string) []string {
+	hosts := []string{`localhost`, `127.0.0.1`, `::1`}
+
+	if h, err := os.Hostname(); err == nil {
+		hosts = append(hosts, h)
+	}
+
+	if h, _, err := net.SplitHostPort(listen); err == nil && h != `` {
+		hosts = append(hosts, h)
+	}
+
+	return hosts
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path"
+	"testing"
+)
+
+func writeTestKey(t *testing.T, p string, pemType string, der []byte) {
+	t.Helper()
+
+	b := pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der})
+
+	err := os.WriteFile(p, b, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSGenerate(t *testing.T) {
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sec1, err := x509.MarshalECPrivateKey(ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		pemType string // Type of existing key, empty if there is no key
+		der     []byte
+	}{
+		{`no key`, ``, nil},
+		{`PKCS #8 key`, `PRIVATE KEY`, pkcs8},
+		{`EC key`, `EC PRIVATE KEY`, sec1},
+		{`RSA key`, `RSA PRIVATE KEY`, x509.MarshalPKCS1PrivateKey(rsaKey)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			cfg := TLS{
+				Certificate: path.Join(dir, `cert.pem`),
+				Key:         path.Join(dir, `key.pem`),
+				Generate:    true,
+			}
+
+			if tt.pemType != `` {
+				writeTestKey(t, cfg.Key, tt.pemType, tt.der)
+			}
+
+			before, _ := os.ReadFile(cfg.Key)
+
+			c, err := cfg.config([]string{`localhost`, `127.0.0.1`})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(c.Certificates) != 1 {
+				t.Fatalf(`got %d certificates`, len(c.Certificates))
+			}
+
+			after, err := os.ReadFile(cfg.Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.pemType != `` && !bytes.Equal(before, after) {
+				t.Fatal(`existing key was replaced`)
+			}
+
+			// Certificate is reused on the next start
+			cert, err := os.ReadFile(cfg.Certificate)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = cfg.config(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			again, _ := os.ReadFile(cfg.Certificate)
+			if !bytes.Equal(cert, again) {
+				t.Fatal(`certificate was generated again`)
+			}
+		})
+	}
+}
+
+func TestTLSGenerateInvalidKey(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg := TLS{
+		Certificate: path.Join(dir, `cert.pem`),
+		Key:         path.Join(dir, `key.pem`),
+		Generate:    true,
+	}
+
+	err := os.WriteFile(cfg.Key, []byte(`not a key`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = cfg.config(nil); err == nil {
+		t.Fatal(`invalid key was accepted`)
+	}
+
+	if _, err = os.Stat(cfg.Certificate); err == nil {
+		t.Fatal(`certificate was written for invalid key`)
+	}
+}
+
+func TestTLSValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   TLS
+		valid bool
+	}{
+		{`minimal`, TLS{Certificate: `/c.pem`, Key: `/k.pem`}, true},
+		{`all`, TLS{Certificate: `/c.pem`, Key: `/k.pem`, MinVersion: `1.3`, ClientCA: `/ca.pem`, ClientAuth: `require`}, true},
+		{`no key`, TLS{Certificate: `/c.pem`}, false},
+		{`relative path`, TLS{Certificate: `c.pem`, Key: `/k.pem`}, false},
+		{`unknown version`, TLS{Certificate: `/c.pem`, Key: `/k.pem`, MinVersion: `1.1`}, false},
+		{`unknown client auth`, TLS{Certificate: `/c.pem`, Key: `/k.pem`, ClientAuth: `bogus`}, false},
+		{`client auth without CA`, TLS{Certificate: `/c.pem`, Key: `/k.pem`, ClientAuth: `request`}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.validate()
+			if (err == nil) != tt.valid {
+				t.Fatalf(`got error %v, want valid %v`, err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"time"
+)
+
+// forwarderTimeout is the write and read timeout of forwarder queries
+const forwarderTimeout = 2 * time.Second
+
+// Reasons of mismatching forwarder replies
+const (
+	mismatchID       = `id`
+	mismatchResponse = `not-response`
+	mismatchQuestion = `question`
+	mismatchCase     = `case` // Randomized case of the name wasn't echoed
+)
+
+// upstreamQuery copies req for sending to a forwarder with a random ID and, if enabled,
+// randomized case of the name (DNS 0x20) so that spoofed replies are harder to get accepted
+func (s *Service) upstreamQuery(req *dns.Msg) *dns.Msg {
+	out := req.Copy()
+	out.Id = dns.Id()
+
+	if s.caseRandomization {
+		out.Question[0].Name = randomCase(out.Question[0].Name)
+	}
+
+	return out
+}
+
+// randomCase randomizes case of letters in name
+func randomCase(name string) string {
+	b := []byte(name)
+	r := make([]byte, len(b))
+
+	_, err := rand.Read(r)
+	if err != nil {
+		return name
+	}
+
+	for i, c := range b {
+		if r[i]&1 == 0 {
+			continue
+		}
+
+		switch {
+		case c >= 'a' && c <= 'z':
+			b[i] = c - 'a' + 'A'
+		case c >= 'A' && c <= 'Z':
+			b[i] = c - 'A' + 'a'
+		}
+	}
+
+	return string(b)
+}
+
+// mismatch checks that reply r answers query q, empty if it does
+func (s *Service) mismatch(q *dns.Msg, r *dns.Msg) string {
+	if r.Id != q.Id {
+		return mismatchID
+	}
+
+	if !r.Response {
+		return mismatchResponse
+	}
+
+	// Errors may come without the question
+	if len(r.Question) == 0 && r.Rcode != dns.RcodeSuccess {
+		return ``
+	}
+
+	if len(r.Question) != 1 {
+		return mismatchQuestion
+	}
+
+	want, got := q.Question[0], r.Question[0]
+
+	if got.Qtype != want.Qtype || got.Qclass != want.Qclass || !strings.EqualFold(got.Name, want.Name) {
+		return mismatchQuestion
+	}
+
+	if s.caseRandomization && got.Name != want.Name {
+		return mismatchCase
+	}
+
+	return ``
+}
+
+// exchange sends query q to forwarder upstream and waits for a reply matching it.
+// Mismatching UDP replies, which can be spoofed or late replies to earlier queries, are counted and ignored.
+func (s *Service) exchange(q *dns.Msg, upstream string) (reply *dns.Msg, rtt time.Duration, err error) {
+	co, err := s.dnsClient.Dial(upstream)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer co.Close()
+
+	start := time.Now()
+
+	err = co.SetWriteDeadline(start.Add(forwarderTimeout))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = co.WriteMsg(q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = co.SetReadDeadline(time.Now().Add(forwarderTimeout))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	_, udp := co.Conn.(net.PacketConn)
+
+	for {
+		reply, err = co.ReadMsg()
+		if err != nil {
+			return nil, time.Since(start), err
+		}
+
+		reason := s.mismatch(q, reply)
+		if reason == `` {
+			return reply, time.Since(start), nil
+		}
+
+		s.metrics.forwarderMismatch.Inc(upstream, reason)
+
+		if !udp {
+			return nil, time.Since(start), fmt.Errorf(`mismatching reply: %s`, reason)
+		}
+	}
+}
+
+// restoreCase sets owner names of records in reply which are the randomized question name back to name
+func restoreCase(reply *dns.Msg, name string) {
+	for _, rrs := range [][]dns.RR{reply.Answer, reply.Ns} {
+		for _, rr := range rrs {
+			if hdr := rr.Header(); strings.EqualFold(hdr.Name, name) {
+				hdr.Name = name
+			}
+		}
+	}
+}
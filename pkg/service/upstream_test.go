@@ -0,0 +1,158 @@
+package service
+
+import (
+	"github.com/miekg/dns"
+	"strings"
+	"testing"
+)
+
+func TestMismatch(t *testing.T) {
+	query := func(name string) *dns.Msg {
+		m := &dns.Msg{}
+		m.SetQuestion(name, dns.TypeA)
+		m.Id = 1234
+		return m
+	}
+
+	tests := []struct {
+		name  string
+		q     *dns.Msg
+		reply func(q *dns.Msg) *dns.Msg
+		x20   bool // Case randomization enabled
+		want  string
+	}{
+		{`match`, query(`example.com.`), func(q *dns.Msg) *dns.Msg {
+			return new(dns.Msg).SetReply(q)
+		}, false, ``},
+		{`wrong ID`, query(`example.com.`), func(q *dns.Msg) *dns.Msg {
+			r := new(dns.Msg).SetReply(q)
+			r.Id++
+			return r
+		}, false, mismatchID},
+		{`not a response`, query(`example.com.`), func(q *dns.Msg) *dns.Msg {
+			r := q.Copy()
+			return r
+		}, false, mismatchResponse},
+		{`error without question`, query(`example.com.`), func(q *dns.Msg) *dns.Msg {
+			r := new(dns.Msg).SetRcode(q, dns.RcodeFormatError)
+			r.Question = nil
+			return r
+		}, false, ``},
+		{`success without question`, query(`example.com.`), func(q *dns.Msg) *dns.Msg {
+			r := new(dns.Msg).SetReply(q)
+			r.Question = nil
+			return r
+		}, false, mismatchQuestion},
+		{`other name`, query(`example.com.`), func(q *dns.Msg) *dns.Msg {
+			r := new(dns.Msg).SetReply(q)
+			r.Question[0].Name = `example.net.`
+			return r
+		}, false, mismatchQuestion},
+		{`other type`, query(`example.com.`), func(q *dns.Msg) *dns.Msg {
+			r := new(dns.Msg).SetReply(q)
+			r.Question[0].Qtype = dns.TypeAAAA
+			return r
+		}, false, mismatchQuestion},
+		{`other class`, query(`example.com.`), func(q *dns.Msg) *dns.Msg {
+			r := new(dns.Msg).SetReply(q)
+			r.Question[0].Qclass = dns.ClassCHAOS
+			return r
+		}, false, mismatchQuestion},
+		{`two questions`, query(`example.com.`), func(q *dns.Msg) *dns.Msg {
+			r := new(dns.Msg).SetReply(q)
+			r.Question = append(r.Question, r.Question[0])
+			return r
+		}, false, mismatchQuestion},
+		{`case not echoed`, query(`eXaMpLe.CoM.`), func(q *dns.Msg) *dns.Msg {
+			r := new(dns.Msg).SetReply(q)
+			r.Question[0].Name = `example.com.`
+			return r
+		}, true, mismatchCase},
+		{`case echoed`, query(`eXaMpLe.CoM.`), func(q *dns.Msg) *dns.Msg {
+			return new(dns.Msg).SetReply(q)
+		}, true, ``},
+		{`case ignored without randomization`, query(`eXaMpLe.CoM.`), func(q *dns.Msg) *dns.Msg {
+			r := new(dns.Msg).SetReply(q)
+			r.Question[0].Name = `example.com.`
+			return r
+		}, false, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{caseRandomization: tt.x20}
+
+			if got := s.mismatch(tt.q, tt.reply(tt.q)); got != tt.want {
+				t.Fatalf(`got %q, want %q`, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreCase(t *testing.T) {
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return r
+	}
+
+	reply := &dns.Msg{
+		Answer: []dns.RR{
+			rr(`WwW.eXaMpLe.CoM. 300 IN CNAME cdn.Example.NET.`),
+			rr(`cdn.Example.NET. 300 IN A 192.0.2.1`),
+		},
+		Ns: []dns.RR{
+			rr(`WWW.EXAMPLE.COM. 300 IN SOA ns.example.com. hostmaster.example.com. 1 3600 600 86400 300`),
+		},
+	}
+
+	restoreCase(reply, `www.example.com.`)
+
+	want := []string{`www.example.com.`, `cdn.Example.NET.`, `www.example.com.`}
+	got := []string{reply.Answer[0].Header().Name, reply.Answer[1].Header().Name, reply.Ns[0].Header().Name}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf(`record %d: got owner %q, want %q`, i, got[i], want[i])
+		}
+	}
+
+	// Record data is not changed
+	if target := reply.Answer[0].(*dns.CNAME).Target; target != `cdn.Example.NET.` {
+		t.Fatalf(`got target %q`, target)
+	}
+}
+
+func TestUpstreamQuery(t *testing.T) {
+	req := &dns.Msg{}
+	req.SetQuestion(`www.example-with-a-long-name.com.`, dns.TypeA)
+
+	for _, x20 := range []bool{false, true} {
+		s := &Service{caseRandomization: x20}
+		changed := false
+
+		// Randomizing the case of 30 letters leaves it unchanged only with probability 2^-30
+		for i := 0; i < 2; i++ {
+			out := s.upstreamQuery(req)
+
+			if !strings.EqualFold(out.Question[0].Name, req.Question[0].Name) {
+				t.Fatalf(`got name %q`, out.Question[0].Name)
+			}
+
+			if out.Question[0].Name != req.Question[0].Name {
+				changed = true
+			}
+		}
+
+		if changed != x20 {
+			t.Fatalf(`randomization %v: name changed %v`, x20, changed)
+		}
+
+		if req.Question[0].Name != `www.example-with-a-long-name.com.` {
+			t.Fatal(`original query was changed`)
+		}
+	}
+}